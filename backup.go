@@ -0,0 +1,299 @@
+// backup.go - archives of the existing Tomcat installation and configuration
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+)
+
+const confBackupName = "conf-backup-" // Filename prefix of configuration backups
+
+// writeTarGz saves the src directory as a gzip compressed tarball to the named file.
+// The archive paths are relative to the parent of src. Any path relative to src
+// that the optional skip function returns true for is excluded.
+func writeTarGz(src, name string, skip func(rel string, info os.FileInfo) bool) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	root := filepath.Dir(src)
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if skip != nil && path != src {
+			rel, err := filepath.Rel(src, path)
+			if err != nil {
+				return err
+			}
+			if skip(filepath.ToSlash(rel), info) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		head, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		head.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			head.Name += "/"
+		}
+		if err = tw.WriteHeader(head); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// createConfBackup saves the confDir directory to a timestamped tarball in destDir.
+// The path of the backup is returned.
+func createConfBackup(confDir, destDir string) (string, error) {
+	name := filepath.Join(destDir, fmt.Sprintf("%v%v.tar.gz", confBackupName, time.Now().Format("20060102-150405")))
+	return name, writeTarGz(confDir, name, nil)
+}
+
+// backupInstallation saves the src Tomcat installation to a tarball in destDir named
+// with the installed version and a timestamp, such as tomcat8.5.99-20240101-120000.tar.gz.
+// The *.log and *.txt files of the logs directory are excluded unless includeLogs is set
+// and the webapps directory is excluded when excludeWebapps is set. The path of the
+// backup is returned.
+func backupInstallation(src, destDir string, includeLogs, excludeWebapps bool) (string, error) {
+	dir, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return "", err
+	}
+	skip := func(rel string, info os.FileInfo) bool {
+		switch {
+		case strings.HasPrefix(rel, "logs/") && !includeLogs && !info.IsDir():
+			ext := strings.ToLower(filepath.Ext(rel))
+			return ext == ".log" || ext == ".txt"
+		case rel == webapps && excludeWebapps:
+			return true
+		}
+		return false
+	}
+	version := filepath.Base(dir)
+	if strings.HasPrefix(version, archiveName) {
+		version = "tomcat" + strings.TrimPrefix(version, archiveName)
+	}
+	name := filepath.Join(destDir, fmt.Sprintf("%v-%v.tar.gz", version, time.Now().Format("20060102-150405")))
+	return name, writeTarGz(dir, name, skip)
+}
+
+// writeChecksumFile saves the SHA-256 checksum of the named file to a name.sha256 sidecar file
+// in the "hex  filename" format. The checksum is returned.
+func writeChecksumFile(name string) (string, error) {
+	sum, err := calcHash(name, sha256.New())
+	if err != nil {
+		return "", err
+	}
+	cs := fmt.Sprintf("%x", sum)
+	return cs, ioutil.WriteFile(name+".sha256", []byte(fmt.Sprintf("%v  %v\n", cs, filepath.Base(name))), 0644)
+}
+
+// latestBackup returns the most recently modified installation backup in dir.
+// Only tarballs with a .sha256 sidecar file are installation backups.
+func latestBackup(dir string) (string, error) {
+	m, err := filepath.Glob(filepath.Join(dir, "*.tar.gz"))
+	if err != nil {
+		return "", err
+	}
+	latest, mod := "", time.Time{}
+	for _, name := range m {
+		if _, err := os.Stat(name + ".sha256"); err != nil {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		if info.ModTime().After(mod) {
+			latest, mod = name, info.ModTime()
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("No installation backups were found in %v", dir)
+	}
+	return latest, nil
+}
+
+// verifyBackup compares the SHA-256 checksum of the backup with its .sha256 sidecar file.
+func verifyBackup(name string) error {
+	data, err := ioutil.ReadFile(name + ".sha256")
+	if err != nil {
+		return err
+	}
+	sum, err := calcHash(name, sha256.New())
+	if err != nil {
+		return err
+	}
+	if want, got := parseChecksum(string(data)), fmt.Sprintf("%x", sum); want != got {
+		return fmt.Errorf("The backup %v does not match its checksum\nExpected: %q\n  Actual: %q", name, want, got)
+	}
+	return nil
+}
+
+// restoreBackup extracts the installation backup name into dest. The top directory
+// of the archive paths is replaced by dest and existing files are overwritten.
+func restoreBackup(name, dest string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		head, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		spl := strings.SplitN(strings.TrimSuffix(head.Name, "/"), "/", 2)
+		rel := ""
+		if len(spl) == 2 {
+			rel = spl[1]
+		}
+		path := filepath.Join(dest, filepath.FromSlash(rel))
+		if path != filepath.Clean(dest) && !strings.HasPrefix(path, filepath.Clean(dest)+string(filepath.Separator)) {
+			return fmt.Errorf("The backup %v has an item outside of the install: %v", name, head.Name)
+		}
+		mode := head.FileInfo().Mode()
+		switch head.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(path, mode.Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			os.Remove(path)
+			if err = os.Symlink(head.Linkname, path); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// listConfBackups returns the configuration backups in dir, newest first.
+func listConfBackups(dir string) ([]os.FileInfo, error) {
+	m, err := filepath.Glob(filepath.Join(dir, confBackupName+"*.tar.gz"))
+	if err != nil {
+		return nil, err
+	}
+	var backups []os.FileInfo
+	for _, name := range m {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, info)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ModTime().After(backups[j].ModTime())
+	})
+	return backups, nil
+}
+
+// pruneConfBackups removes all but the newest keep configuration backups in dir.
+// The newest backup is always kept. The paths of the removed backups are returned.
+func pruneConfBackups(dir string, keep int) ([]string, error) {
+	if keep < 1 {
+		keep = 1
+	}
+	backups, err := listConfBackups(dir)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for i, b := range backups {
+		if i < keep {
+			continue
+		}
+		name := filepath.Join(dir, b.Name())
+		if err := os.Remove(name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
+
+// printConfBackups lists the configuration backups in dir with their timestamps and sizes.
+func printConfBackups(dir string) error {
+	backups, err := listConfBackups(dir)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		fmt.Printf("No configuration backups found in %v\n", dir)
+		return nil
+	}
+	for _, b := range backups {
+		fmt.Printf("%v  %-10v %v\n", b.ModTime().Format("2006-01-02 15:04:05"), humanize.Bytes(uint64(b.Size())), filepath.Join(dir, b.Name()))
+	}
+	return nil
+}
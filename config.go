@@ -0,0 +1,89 @@
+// config.go - settings loaded from a TOML configuration file
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config are the settings of a TOML configuration file.
+// Any setting that is not in the file keeps its default value.
+type Config struct {
+	TomcatDir   string        `toml:"tomcatDir"`   // location of the Tomcat installation
+	Conf        string        `toml:"conf"`        // Tomcat configuration sub-directory
+	UserID      *int          `toml:"userID"`      // user ID to own the new install
+	GroupID     *int          `toml:"groupID"`     // group ID to own the new install
+	Configs     []string      `toml:"configs"`     // configurations to migrate
+	Ignored     []string      `toml:"ignored"`     // paths to ignore when extracting the tarball
+	URLTemplate string        `toml:"urlTemplate"` // template of the archive path or URL
+	Symlinks    []SymlinkPair `toml:"symlinks"`    // symlinks created within the new install
+	Hooks       Hooks         `toml:"hooks"`
+}
+
+// SymlinkPair is a symlink created within the new install.
+type SymlinkPair struct {
+	Target string `toml:"target"` // path the symlink points to
+	Link   string `toml:"link"`   // path of the symlink within the new install
+}
+
+// check returns an error if the link is not a path within the Tomcat install.
+func (p SymlinkPair) check() error {
+	if p.Target == "" || p.Link == "" {
+		return fmt.Errorf("The symlink %v → %v needs both a target and a link", p.Link, p.Target)
+	}
+	if l := filepath.Clean(p.Link); filepath.IsAbs(l) || l == ".." || strings.HasPrefix(l, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("The symlink %v for %v must be a path within the Tomcat install", p.Link, p.Target)
+	}
+	return nil
+}
+
+// Hooks are shell commands run before and after the update.
+type Hooks struct {
+	Pre  string `toml:"pre"`  // run before the archive is downloaded
+	Post string `toml:"post"` // run after the update is complete
+}
+
+// loadConfig reads the TOML configuration file.
+func loadConfig(path string) (*Config, error) {
+	var c Config
+	if _, err := toml.DecodeFile(path, &c); err != nil {
+		return nil, fmt.Errorf("The configuration file %v could not be read: %v", path, err)
+	}
+	for _, s := range c.Symlinks {
+		if err := s.check(); err != nil {
+			return nil, fmt.Errorf("%v in %v", err, path)
+		}
+	}
+	return &c, nil
+}
+
+// applyConfig replaces the defaults with the settings of the configuration file.
+// Settings given as command line flags take precedence.
+func applyConfig(c *Config) {
+	if c.TomcatDir != "" && isFlagSet("dir") == false {
+		tomcatDir = c.TomcatDir
+	}
+	if c.URLTemplate != "" && isFlagSet("apache-dist-path") == false {
+		distPath = c.URLTemplate
+	}
+	if c.Conf != "" {
+		conf = c.Conf
+	}
+	if c.UserID != nil {
+		userID = *c.UserID
+	}
+	if c.GroupID != nil {
+		groupID = *c.GroupID
+	}
+	if len(c.Ignored) > 0 {
+		ignored = c.Ignored
+	}
+	if len(c.Symlinks) > 0 {
+		symlinks = c.Symlinks
+	}
+	preHook, postHook = c.Hooks.Pre, c.Hooks.Post
+}
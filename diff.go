@@ -0,0 +1,193 @@
+// diff.go - line differences of the migrated configurations
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// maxDiffCells limits the memory used to compare the changed lines of two files.
+// Larger changes are shown as the replacement of every changed line.
+const maxDiffCells = 4000000
+
+// diffOp is a line of an edit script. The kind is ' ' for a kept line,
+// '-' for a removed line and '+' for an added line.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns the edit script that changes the lines of a into b.
+func diffLines(a, b []string) []diffOp {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	var ops []diffOp
+	for _, l := range a[:pre] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	ops = append(ops, diffChanged(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	for _, l := range a[len(a)-suf:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops
+}
+
+// diffChanged returns the edit script of a and b using their longest common subsequence.
+func diffChanged(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	var ops []diffOp
+	if n*m > maxDiffCells {
+		for _, l := range a {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range b {
+			ops = append(ops, diffOp{'+', l})
+		}
+		return ops
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// unifiedDiff returns the differences of the lines of a and b in the unified diff format,
+// or an empty string when they are the same.
+func unifiedDiff(aName, bName string, a, b []string) string {
+	ops := diffLines(a, b)
+	// the number of lines of a and b before each operation
+	aPos, bPos := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for k, op := range ops {
+		aPos[k+1], bPos[k+1] = aPos[k], bPos[k]
+		if op.kind != '+' {
+			aPos[k+1]++
+		}
+		if op.kind != '-' {
+			bPos[k+1]++
+		}
+	}
+	var sb strings.Builder
+	for k := 0; k < len(ops); k++ {
+		if ops[k].kind == ' ' {
+			continue
+		}
+		// join the changes that are separated by less than two contexts
+		end := k + 1
+		for {
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContext {
+				break
+			}
+			for next < len(ops) && ops[next].kind != ' ' {
+				next++
+			}
+			end = next
+		}
+		start, stop := k-diffContext, end+diffContext
+		if start < 0 {
+			start = 0
+		}
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %v\n+++ %v\n", aName, bName)
+		}
+		fmt.Fprintf(&sb, "@@ -%v +%v @@\n", hunkRange(aPos[start], aPos[stop]), hunkRange(bPos[start], bPos[stop]))
+		for _, op := range ops[start:stop] {
+			fmt.Fprintf(&sb, "%c%v\n", op.kind, op.line)
+		}
+		k = stop - 1
+	}
+	return sb.String()
+}
+
+// hunkRange returns the start,count range of a hunk header.
+func hunkRange(from, to int) string {
+	if to-from == 0 {
+		return fmt.Sprintf("%v,0", from)
+	}
+	if to-from == 1 {
+		return fmt.Sprintf("%v", from+1)
+	}
+	return fmt.Sprintf("%v,%v", from+1, to-from)
+}
+
+// readLines returns the lines of the file, or no lines if it does not exist.
+func readLines(name string) ([]string, error) {
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return nil, nil
+	}
+	return strings.Split(s, "\n"), nil
+}
+
+// diffFiles returns the unified diff of the replacement of the old file by the new file.
+func diffFiles(oldName, newName string) (string, error) {
+	a, err := readLines(oldName)
+	if err != nil {
+		return "", err
+	}
+	b, err := readLines(newName)
+	if err != nil {
+		return "", err
+	}
+	return unifiedDiff(oldName, newName, a, b), nil
+}
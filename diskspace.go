@@ -0,0 +1,17 @@
+//go:build linux || darwin
+// +build linux darwin
+
+// diskspace.go - free space checks of the file systems used by Tomcat
+
+package main
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users on the file system of dir.
+func freeSpace(dir string) (uint64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return 0, err
+	}
+	return uint64(fs.Bavail) * uint64(fs.Bsize), nil
+}
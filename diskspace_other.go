@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+// diskspace_other.go - free space checks of the file systems used by Tomcat
+
+package main
+
+// freeSpace is not supported on Windows and the BSDs, whose file system statistics differ.
+func freeSpace(dir string) (uint64, error) {
+	return 0, ErrFreeSpace
+}
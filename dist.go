@@ -0,0 +1,196 @@
+// dist.go - download locations of the Tomcat distributions
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// URLBuilder returns the download locations of a Tomcat distribution.
+type URLBuilder interface {
+	ArchiveURL(major, minor, patch int) string
+	ChecksumURL(major, minor, patch int) string
+}
+
+// distVars are the values available to the archive path template.
+type distVars struct {
+	Major    string
+	Minor    string
+	Patch    int
+	Filename string
+}
+
+func newDistVars(major, minor, patch int) distVars {
+	return distVars{
+		Major:    fmt.Sprint(major),
+		Minor:    fmt.Sprint(minor),
+		Patch:    patch,
+		Filename: fmt.Sprintf("%v%v.%v.%v.tar.gz", archiveName, major, minor, patch),
+	}
+}
+
+// apacheDist is the prefix of the archive URLs that is replaced by a --base-url mirror.
+var apacheDist = urlBase + "dist/tomcat/"
+
+// apacheURLs are the locations of the Apache Software Foundation releases.
+// Archives can be downloaded from a mirror but the checksums always come from apache.org,
+// so a compromised mirror cannot supply a matching checksum of a malicious archive.
+type apacheURLs struct {
+	path   string // template of the archive path on urlBase, or a complete URL
+	mirror string // base URL of a mirror that replaces apacheDist, such as https://dlcdn.apache.org/tomcat/
+}
+
+func (a apacheURLs) ArchiveURL(major, minor, patch int) string {
+	u, _ := buildURLs(a.path, newDistVars(major, minor, patch))
+	if a.mirror != "" && strings.HasPrefix(u, apacheDist) {
+		return strings.TrimSuffix(a.mirror, "/") + "/" + strings.TrimPrefix(u, apacheDist)
+	}
+	return u
+}
+
+func (a apacheURLs) ChecksumURL(major, minor, patch int) string {
+	u, _ := buildURLs(a.path, newDistVars(major, minor, patch))
+	return u + ".sha512"
+}
+
+// templateURLs are the locations of a distribution using an operator supplied URL template.
+// The vmware and redhat downloads are specific to a subscription so their locations must be supplied.
+type templateURLs struct {
+	url string // template of the complete archive URL
+}
+
+func (t templateURLs) ArchiveURL(major, minor, patch int) string {
+	u, _ := buildURLs(t.url, newDistVars(major, minor, patch))
+	return u
+}
+
+func (t templateURLs) ChecksumURL(major, minor, patch int) string {
+	return t.ArchiveURL(major, minor, patch) + ".sha512"
+}
+
+// apacheDownloads is the host that the archive downloads of apacheDist are redirected to.
+const apacheDownloads = "downloads.apache.org"
+
+// downloadHost returns the name of the host that serves the archives of the distribution.
+func downloadHost(b URLBuilder) (string, error) {
+	src := b.ArchiveURL(0, 0, 0)
+	if strings.HasPrefix(src, apacheDist) {
+		return apacheDownloads, nil
+	}
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", err
+	}
+	return u.Hostname(), nil
+}
+
+// newURLBuilder returns the URLBuilder of the named distribution.
+// The apache distribution uses distPath and the optional mirror, all others require the distURL template.
+func newURLBuilder(dist, distPath, distURL, mirror string) (URLBuilder, error) {
+	tmpl := distPath
+	var b URLBuilder = apacheURLs{path: distPath, mirror: mirror}
+	if mirror != "" && dist != "apache" {
+		return nil, fmt.Errorf("The --base-url mirror can only be used with the apache distribution")
+	}
+	switch dist {
+	case "apache":
+		if mirror == "" {
+			break
+		}
+		if u, err := url.Parse(mirror); err != nil || !u.IsAbs() {
+			return nil, fmt.Errorf("The --base-url %q must be a complete URL", mirror)
+		}
+		if err := requireHTTPS(mirror); err != nil {
+			return nil, err
+		}
+		if u, _ := buildURLs(distPath, newDistVars(0, 0, 0)); !strings.HasPrefix(u, apacheDist) {
+			return nil, fmt.Errorf("The --base-url mirror needs an -apache-dist-path within %v", apacheDist)
+		}
+	case "vmware", "redhat", "custom":
+		if distURL == "" {
+			return nil, fmt.Errorf("The %v distribution requires an archive URL template, use --dist-url", dist)
+		}
+		if u, err := url.Parse(distURL); err != nil || !u.IsAbs() {
+			return nil, fmt.Errorf("The --dist-url %q must be a complete URL", distURL)
+		}
+		tmpl = distURL
+		b = templateURLs{url: distURL}
+	default:
+		return nil, fmt.Errorf("Unknown distribution %q, use apache, vmware, redhat or custom", dist)
+	}
+	// check the template renders to a valid HTTPS URL
+	u, err := buildURLs(tmpl, newDistVars(0, 0, 0))
+	if err != nil {
+		return nil, err
+	}
+	if err = requireHTTPS(u); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// requireHTTPS returns an error if the URL does not use the https scheme.
+func requireHTTPS(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("The URL %q must use https, plain %v downloads are refused", rawURL, u.Scheme)
+	}
+	return nil
+}
+
+// buildURLs renders the distPath template to return the URL of an archive.
+// A distPath that is not a complete URL is treated as a path on urlBase.
+func buildURLs(distPath string, v distVars) (string, error) {
+	tmpl, err := template.New("dist").Parse(distPath)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err = tmpl.Execute(&b, v); err != nil {
+		return "", err
+	}
+	src := b.String()
+	if u, err := url.Parse(src); err != nil || !u.IsAbs() {
+		src = urlBase + strings.TrimPrefix(src, "/")
+	}
+	if _, err := url.ParseRequestURI(src); err != nil {
+		return "", fmt.Errorf("The archive URL %q is not valid: %v", src, err)
+	}
+	return src, nil
+}
+
+// basicAuthTransport adds HTTP basic authentication to the requests of the HTTP client
+// that are sent to the host of the distribution. Requests to other hosts, including
+// the apache.org KEYS and redirects to other hosts, are not sent the credentials.
+type basicAuthTransport struct {
+	user, password string
+	host           string
+	base           http.RoundTripper
+}
+
+func (t basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if strings.EqualFold(req.URL.Host, t.host) == false {
+		return base.RoundTrip(req)
+	}
+	r := req.Clone(req.Context())
+	r.SetBasicAuth(t.user, t.password)
+	return base.RoundTrip(r)
+}
+
+// roundTripperFunc is a function that implements the http.RoundTripper interface.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,166 @@
+// gpg.go - PGP signature verification of the Tomcat archives
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// keysURL returns the location of the KEYS file of the Apache Tomcat release managers.
+func keysURL(major string) string {
+	return fmt.Sprintf("https://downloads.apache.org/tomcat/tomcat-%v/KEYS", major)
+}
+
+// signingKeys returns the location of the KEYS file of the distribution signers,
+// which is the --dist-keys-url or the KEYS of the Apache Tomcat release managers.
+func (u *Updater) signingKeys(major string) string {
+	if u.distKeys != "" {
+		return u.distKeys
+	}
+	return keysURL(major)
+}
+
+// keysCache returns the path of the local copy of the KEYS file at url.
+func keysCache(major, url string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("tomcat-%v-KEYS", major)
+	if url != keysURL(major) {
+		name = fmt.Sprintf("dist-%x-KEYS", sha256.Sum256([]byte(url)))
+	}
+	return filepath.Join(dir, "tomcatupdate", name), nil
+}
+
+// fetchBody GETs the url and returns the response body.
+func (u *Updater) fetchBody(url string) ([]byte, error) {
+	resp, err := u.fetchWithRetry(url, retries+1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v returned %v", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// loadKeyRing returns the keys of the distribution signers for the major version.
+// The KEYS file is cached locally and is downloaded again when refresh is set.
+func (u *Updater) loadKeyRing(major string, refresh bool) (openpgp.EntityList, error) {
+	url := u.signingKeys(major)
+	cache, err := keysCache(major, url)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(cache)
+	if refresh || err != nil {
+		if data, err = u.fetchBody(url); err != nil {
+			return nil, err
+		}
+		if err = os.MkdirAll(filepath.Dir(cache), 0755); err == nil {
+			ioutil.WriteFile(cache, data, 0644)
+		}
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("The KEYS file %v could not be read: %v", url, err)
+	}
+	return keyring, nil
+}
+
+// verifySignature checks the archive against the detached PGP signature at sigURL
+// using the KEYS of the distribution signers. The cached KEYS file is
+// refreshed once if the signer is not found in it. The signer is returned.
+func (u *Updater) verifySignature(archive, sigURL, major string) (string, error) {
+	sig, err := u.fetchBody(sigURL)
+	if err != nil {
+		return "", err
+	}
+	var signer *openpgp.Entity
+	for _, refresh := range []bool{false, true} {
+		keyring, err := u.loadKeyRing(major, refresh)
+		if err != nil {
+			return "", err
+		}
+		file, err := os.Open(archive)
+		if err != nil {
+			return "", err
+		}
+		signer, err = openpgp.CheckArmoredDetachedSignature(keyring, file, bytes.NewReader(sig))
+		file.Close()
+		if err == nil {
+			break
+		}
+		if refresh {
+			return "", fmt.Errorf("%w, %v: %v", ErrSignature, archive, err)
+		}
+	}
+	return signerName(signer), nil
+}
+
+// verifyStream checks the archive data read from r against the detached PGP signature
+// at sigURL. As a stream can only be read once, the cached KEYS file is refreshed
+// before the check when it does not have the key of the signer.
+func (u *Updater) verifyStream(r io.Reader, sigURL, major string) (string, error) {
+	sig, err := u.fetchBody(sigURL)
+	if err != nil {
+		return "", err
+	}
+	keyring, err := u.loadKeyRing(major, false)
+	if err != nil {
+		return "", err
+	}
+	if id, ok := signatureIssuer(sig); ok == false || len(keyring.KeysById(id)) == 0 {
+		if keyring, err = u.loadKeyRing(major, true); err != nil {
+			return "", err
+		}
+	}
+	signer, err := openpgp.CheckArmoredDetachedSignature(keyring, r, bytes.NewReader(sig))
+	if err != nil {
+		return "", fmt.Errorf("%w, %v: %v", ErrSignature, strings.TrimSuffix(sigURL, ".asc"), err)
+	}
+	return signerName(signer), nil
+}
+
+// signatureIssuer returns the key ID of the signer of the armored detached signature.
+func signatureIssuer(sig []byte) (uint64, bool) {
+	block, err := armor.Decode(bytes.NewReader(sig))
+	if err != nil {
+		return 0, false
+	}
+	p, err := packet.NewReader(block.Body).Next()
+	if err != nil {
+		return 0, false
+	}
+	switch s := p.(type) {
+	case *packet.Signature:
+		if s.IssuerKeyId != nil {
+			return *s.IssuerKeyId, true
+		}
+	case *packet.SignatureV3:
+		return s.IssuerKeyId, true
+	}
+	return 0, false
+}
+
+// signerName returns an identity of the signer.
+func signerName(signer *openpgp.Entity) string {
+	for name := range signer.Identities {
+		return name
+	}
+	return "unknown signer"
+}
@@ -0,0 +1,55 @@
+// health.go - wait for the updated Tomcat to respond
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	healthInterval = 2 * time.Second   // Time between the polls of the health endpoint
+	healthTimeout  = 120 * time.Second // Time the health endpoint is polled before giving up
+)
+
+// healthy returns true if the status code shows the server is up. A 405 Method Not Allowed
+// response is accepted as the server responded, but it does not allow HEAD requests.
+func healthy(status int) bool {
+	return (status >= 200 && status < 400) || status == http.StatusMethodNotAllowed
+}
+
+// waitForHealthy polls the --health-endpoint URL with the --health-endpoint-method until
+// Tomcat responds or the healthTimeout is reached. The default HEAD method avoids
+// transferring the response body of every poll.
+func (u *Updater) waitForHealthy(ctx context.Context, url string) error {
+	// a separate client as the endpoint is usually a local plain http URL
+	client := &http.Client{Timeout: healthInterval}
+	deadline := time.Now().Add(healthTimeout)
+	status := "no response"
+	for {
+		req, err := http.NewRequestWithContext(ctx, u.healthMethod, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if healthy(resp.StatusCode) {
+				addEvent("health", fmt.Sprintf("%v %v %v", u.healthMethod, url, resp.Status), true)
+				return nil
+			}
+			status = resp.Status
+		}
+		if time.Now().After(deadline) {
+			addEvent("health", fmt.Sprintf("%v %v %v", u.healthMethod, url, status), false)
+			return fmt.Errorf("Tomcat at %v was not healthy after %v, the last response was %v", url, healthTimeout, status)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(healthInterval):
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// java.go - checks the Java runtime is compatible with the Tomcat release
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// minJava is the oldest Java version supported by each Tomcat release series.
+var minJava = map[string]int{
+	"8.5":  7,
+	"9.0":  8,
+	"10.0": 8,
+	"10.1": 11,
+	"11.0": 17,
+}
+
+// javaVersionOutput matches the version printed by java -version.
+var javaVersionOutput = regexp.MustCompile(`version "([^"]+)"`)
+
+// javaMajor returns the major number of a Java version, such as 8 for 1.8.0_292 or 11 for 11.0.2.
+func javaMajor(version string) (int, error) {
+	v := strings.TrimPrefix(version, "1.")
+	if i := strings.IndexAny(v, ".-+_"); i > 0 {
+		v = v[:i]
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("The Java version %q could not be read", version)
+	}
+	return n, nil
+}
+
+// javaRelease returns the JAVA_VERSION of the release file of a Java install.
+func javaRelease(javaHome string) (string, error) {
+	f, err := os.Open(filepath.Join(javaHome, "release"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v := strings.TrimPrefix(scanner.Text(), "JAVA_VERSION="); v != scanner.Text() {
+			return strings.Trim(v, `"`), nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%v has no JAVA_VERSION", f.Name())
+}
+
+// installedJava returns the version of the Java runtime in javaHome, or of the java
+// command on the PATH when javaHome is empty.
+func installedJava(javaHome string) (string, error) {
+	java := "java"
+	if javaHome != "" {
+		if v, err := javaRelease(javaHome); err == nil {
+			return v, nil
+		}
+		java = filepath.Join(javaHome, "bin", "java")
+	}
+	out, err := exec.Command(java, "-version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("The Java version could not be found using %v -version: %v", java, err)
+	}
+	m := javaVersionOutput.FindSubmatch(out)
+	if m == nil {
+		return "", fmt.Errorf("The Java version could not be found in the output of %v -version", java)
+	}
+	return string(m[1]), nil
+}
+
+// checkJavaVersion returns an error if the Java runtime in javaHome, or on the PATH
+// when javaHome is empty, is older than the minJava major version of the Tomcat series.
+func checkJavaVersion(javaHome, series string, minJava int) error {
+	v, err := installedJava(javaHome)
+	if err != nil {
+		return err
+	}
+	major, err := javaMajor(v)
+	if err != nil {
+		return err
+	}
+	if major < minJava {
+		return fmt.Errorf("Tomcat %v requires Java %v or newer but Java %v is installed, use --java-home to select another Java", series, minJava, v)
+	}
+	return nil
+}
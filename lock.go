@@ -0,0 +1,49 @@
+//go:build !windows
+// +build !windows
+
+// lock.go - prevent concurrent runs from changing the same Tomcat install
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock of the named file and saves the process ID to it.
+// An error wrapping ErrLocked, with the process ID of the holder, is returned when
+// the file is already locked by another process.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		pid, _ := ioutil.ReadAll(f)
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("%w, %v is held by process %v", ErrLocked, path, strings.TrimSpace(string(pid)))
+		}
+		return nil, err
+	}
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+	}
+	if err != nil {
+		unlockFile(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+// unlockFile releases the lock taken by lockFile.
+func unlockFile(f *os.File) {
+	if f == nil {
+		return
+	}
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	f.Close()
+}
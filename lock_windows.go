@@ -0,0 +1,14 @@
+// lock_windows.go - prevent concurrent runs from changing the same Tomcat install
+
+package main
+
+import "os"
+
+// lockFile is not supported on Windows, it only lets the tool build
+// so that init can report that Windows is not supported.
+func lockFile(path string) (*os.File, error) {
+	return nil, nil
+}
+
+// unlockFile is not supported on Windows.
+func unlockFile(f *os.File) {}
@@ -0,0 +1,46 @@
+// logfile.go - write the operation logs of a run to a file or the system logger
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"time"
+)
+
+// openLogFile opens the named log file for appending, creating it when missing.
+// A file that has grown to maxSize bytes or more is first rotated by renaming it
+// with a timestamp suffix, such as tomcatupdate.log.20201231-235959.
+// A maxSize of 0 never rotates the file.
+func openLogFile(name string, maxSize uint64) (*os.File, error) {
+	if maxSize > 0 {
+		if st, err := os.Stat(name); err == nil && st.Mode().IsRegular() && uint64(st.Size()) >= maxSize {
+			if err := os.Rename(name, name+"."+time.Now().Format("20060102-150405")); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+// syslogDebug is a writer that sends each complete line of the terminal output
+// to the system logger with the DEBUG level, for use with --syslog and --verbose.
+type syslogDebug struct {
+	buf []byte
+}
+
+func (w *syslogDebug) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimSpace(string(w.buf[:i])); line != "" {
+			syslogMsg("DEBUG", line)
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,223 @@
+// manifest.go - record of the files of a new install for audits and later verification
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifestName is the manifest file saved in the root of the new install.
+const manifestName = ".tomcatupdate-manifest.json"
+
+// Manifest lists the files of an install with their checksums, permissions and ownership.
+type Manifest struct {
+	Version string         `json:"version"`
+	Created time.Time      `json:"created"`
+	Files   []ManifestFile `json:"files"`
+}
+
+// ManifestFile is a regular file of an install, the path is relative to the install directory.
+type ManifestFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Mode   string `json:"mode"`
+	UID    int    `json:"uid"`
+	GID    int    `json:"gid"`
+}
+
+// manifestEntry returns the manifest record of the file at path within dir.
+func manifestEntry(dir, path string, info os.FileInfo) (ManifestFile, error) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	sum, err := calcHash(path, sha256.New())
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	uid, gid := fileOwner(info)
+	return ManifestFile{
+		Path:   filepath.ToSlash(rel),
+		SHA256: fmt.Sprintf("%x", sum),
+		Mode:   fmt.Sprintf("%04o", info.Mode().Perm()),
+		UID:    uid,
+		GID:    gid,
+	}, nil
+}
+
+// buildManifest returns the manifest of the regular files in dir.
+// The manifest and lock files of the tool and the runtime directories are not listed.
+func buildManifest(ctx context.Context, dir, version string) (Manifest, error) {
+	m := Manifest{Version: version, Created: time.Now().UTC()}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() && path != dir && skipManifest(dir, path) {
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() == false || skipManifest(dir, path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f, err := manifestEntry(dir, path, info)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, f)
+		return nil
+	})
+	return m, err
+}
+
+// runtimeDirs are the sub-directories of an install that Tomcat changes as it runs.
+var runtimeDirs = []string{"logs", "temp", "work"}
+
+// skipManifest returns true for the files of the tool in the root of the install,
+// the runtime directories and the web applications deployed within webapps.
+func skipManifest(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == manifestName || rel == lockName {
+		return true
+	}
+	for _, d := range append(runtimeDirs, webapps) {
+		if strings.HasPrefix(rel, d+"/") {
+			return true
+		}
+	}
+	return contains(runtimeDirs, rel)
+}
+
+// writeManifest saves the manifest as JSON to name. It is written to a temporary
+// file that replaces name, so an interrupted run never leaves a partial manifest.
+func writeManifest(name string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".tomcatupdate-manifest-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if serr := tmp.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// readManifest returns the manifest saved in the install directory.
+func readManifest(dir string) (Manifest, error) {
+	var m Manifest
+	name := filepath.Join(dir, manifestName)
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return m, fmt.Errorf("The install %v has no manifest, it is saved by the update", dir)
+	}
+	if err != nil {
+		return m, err
+	}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("The manifest %v could not be read: %v", name, err)
+	}
+	return m, nil
+}
+
+// compareFile returns the differences of the file to its manifest record, if any.
+func compareFile(want, got ManifestFile) []string {
+	var diff []string
+	if got.SHA256 != want.SHA256 {
+		diff = append(diff, "checksum")
+	}
+	if got.Mode != want.Mode {
+		diff = append(diff, fmt.Sprintf("mode %v, was %v", got.Mode, want.Mode))
+	}
+	if got.UID != want.UID || got.GID != want.GID {
+		diff = append(diff, fmt.Sprintf("owner %v:%v, was %v:%v", got.UID, got.GID, want.UID, want.GID))
+	}
+	return diff
+}
+
+// verifyInstall compares the files of the install in dir to its manifest and prints
+// those that were modified, deleted or added since the update. Nothing is downloaded
+// or changed. The exit code is returned.
+func verifyInstall(ctx context.Context, dir string) (int, error) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return ExitError, err
+	}
+	m, err := readManifest(root)
+	if err != nil {
+		return ExitError, err
+	}
+	current, err := buildManifest(ctx, root, m.Version)
+	if err != nil {
+		return ExitError, err
+	}
+	want := make(map[string]ManifestFile, len(m.Files))
+	for _, f := range m.Files {
+		want[f.Path] = f
+	}
+	var modified, added, deleted []string
+	for _, f := range current.Files {
+		w, ok := want[f.Path]
+		if !ok {
+			added = append(added, f.Path)
+			continue
+		}
+		delete(want, f.Path)
+		if diff := compareFile(w, f); len(diff) > 0 {
+			modified = append(modified, fmt.Sprintf("%v (%v)", f.Path, strings.Join(diff, ", ")))
+		}
+	}
+	for p := range want {
+		deleted = append(deleted, p)
+	}
+	sort.Strings(deleted)
+	for _, p := range modified {
+		fmt.Printf("Modified: %v\n", p)
+	}
+	for _, p := range deleted {
+		fmt.Printf("Deleted:  %v\n", p)
+	}
+	for _, p := range added {
+		fmt.Printf("Added:    %v\n", p)
+	}
+	if len(modified)+len(deleted)+len(added) > 0 {
+		fmt.Printf("Tomcat %v in %v differs from its manifest of %v, %v modified, %v deleted, %v added\n",
+			m.Version, root, m.Created.Local().Format("2006-01-02 15:04"), len(modified), len(deleted), len(added))
+		return ExitVerifyFailed, nil
+	}
+	fmt.Printf("Verified: Tomcat %v, %v files match the manifest\n", m.Version, len(m.Files))
+	return ExitOK, nil
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+// owner.go - file ownership checks
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownedBy returns true if the file is owned by the user and group IDs.
+func ownedBy(info os.FileInfo, uID, gID int) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	return int(st.Uid) == uID && int(st.Gid) == gID
+}
+
+// fileOwner returns the user and group IDs of the file, or -1 if they are unknown.
+func fileOwner(info os.FileInfo) (int, int) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1
+	}
+	return int(st.Uid), int(st.Gid)
+}
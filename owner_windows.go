@@ -0,0 +1,15 @@
+// owner_windows.go - file ownership checks
+
+package main
+
+import "os"
+
+// ownedBy always returns false as Windows does not use user and group IDs.
+func ownedBy(info os.FileInfo, uID, gID int) bool {
+	return false
+}
+
+// fileOwner always returns -1 as Windows does not use user and group IDs.
+func fileOwner(info os.FileInfo) (int, int) {
+	return -1, -1
+}
@@ -0,0 +1,106 @@
+// properties.go - normalisation of Java .properties configurations
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// property is a key=value entry of a .properties file with the lines that make it up.
+type property struct {
+	key   string
+	lines []string // any preceding comments, the entry and its continuation lines
+}
+
+// sortPropertiesFile reads the src .properties file and writes it to dst with the
+// entries sorted by key, ignoring case. Comments that immediately precede an entry
+// are kept with that entry, while other comments are kept at the top of the file.
+func sortPropertiesFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	var header, pending []string
+	var props []property
+	continued := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trim := strings.TrimSpace(line)
+		switch {
+		case continued:
+			props[len(props)-1].lines = append(props[len(props)-1].lines, line)
+		case trim == "":
+			header = append(header, pending...)
+			pending = nil
+			continue
+		case strings.HasPrefix(trim, "#"), strings.HasPrefix(trim, "!"):
+			pending = append(pending, line)
+			continue
+		default:
+			key := trim
+			if i := strings.IndexAny(trim, "=: \t"); i >= 0 {
+				key = trim[:i]
+			}
+			props = append(props, property{key: key, lines: append(pending, line)})
+			pending = nil
+		}
+		continued = strings.HasSuffix(trim, "\\") && !strings.HasSuffix(trim, "\\\\")
+	}
+	file.Close()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	header = append(header, pending...)
+	sort.SliceStable(props, func(i, j int) bool {
+		return strings.ToLower(props[i].key) < strings.ToLower(props[j].key)
+	})
+	var b strings.Builder
+	for _, h := range header {
+		b.WriteString(h + "\n")
+	}
+	if len(header) > 0 {
+		b.WriteString("\n")
+	}
+	for _, p := range props {
+		for _, l := range p.lines {
+			b.WriteString(l + "\n")
+		}
+	}
+	return ioutil.WriteFile(dst, []byte(b.String()), info.Mode())
+}
+
+// stripDebugLogging reads the src logging.properties file and writes it to dst with
+// every FINE, FINER, FINEST or ALL log level replaced by INFO. Comments are kept.
+// The number of replaced levels is returned.
+func stripDebugLogging(src, dst string) (int, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return 0, err
+	}
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return 0, err
+	}
+	re := regexp.MustCompile(`^(\s*(?:[^#!\s=:][^=:\s]*)?\.level\s*[=:]\s*)(?i:FINE|FINER|FINEST|ALL)(\s*)$`)
+	c := 0
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		l := strings.TrimSuffix(line, "\r")
+		if !re.MatchString(l) {
+			continue
+		}
+		lines[i] = re.ReplaceAllString(l, "${1}INFO${2}") + line[len(l):]
+		c++
+	}
+	return c, ioutil.WriteFile(dst, []byte(strings.Join(lines, "\n")), info.Mode())
+}
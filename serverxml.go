@@ -0,0 +1,544 @@
+// serverxml.go - checks and edits of the Tomcat XML configurations
+//
+// Edits are made in place on the raw document so the comments, layout
+// and attribute order of the operator's customised server.xml are kept.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+const (
+	httpProtocol  = "HTTP/1.1"                                   // Protocol of the Tomcat HTTP connector
+	httpsProtocol = "org.apache.coyote.http11.Http11NioProtocol" // Protocol of the Tomcat HTTPS connector
+	ajpProtocol   = "AJP/1.3"                                    // Protocol of the Tomcat AJP connector
+)
+
+// xmlElement is the location and attributes of a start tag within an XML document.
+type xmlElement struct {
+	start, end int64 // byte offsets of the start tag
+	attr       []xml.Attr
+}
+
+// findElements returns every start tag with the local name in data.
+// Elements within comments are ignored.
+func findElements(data []byte, name string) ([]xmlElement, error) {
+	var els []xmlElement
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		off := d.InputOffset()
+		t, err := d.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		se, ok := t.(xml.StartElement)
+		if !ok || se.Name.Local != name {
+			continue
+		}
+		els = append(els, xmlElement{start: off, end: d.InputOffset(), attr: se.Attr})
+	}
+	return els, nil
+}
+
+// attrValue returns the value of the named attribute and whether it exists.
+func attrValue(attrs []xml.Attr, name string) (string, bool) {
+	for _, a := range attrs {
+		if a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// setAttr replaces the value of the named attribute within a raw start tag,
+// or appends the attribute when the tag does not have it.
+func setAttr(tag []byte, name, value string) []byte {
+	var v bytes.Buffer
+	xml.EscapeText(&v, []byte(value))
+	val := fmt.Sprintf("%s=\"%s\"", name, v.String())
+	re := regexp.MustCompile(`(\s)` + regexp.QuoteMeta(name) + `\s*=\s*("[^"]*"|'[^']*')`)
+	if re.Match(tag) {
+		return re.ReplaceAllFunc(tag, func(m []byte) []byte {
+			return append([]byte{m[0]}, val...)
+		})
+	}
+	cl := closing(tag)
+	out := append([]byte{}, bytes.TrimRight(tag[:len(tag)-len(cl)], " \t\r\n")...)
+	out = append(out, ' ')
+	out = append(out, val...)
+	if len(cl) == 2 {
+		out = append(out, ' ')
+	}
+	return append(out, cl...)
+}
+
+// closing returns the characters that close a raw start tag.
+func closing(tag []byte) []byte {
+	if bytes.HasSuffix(tag, []byte("/>")) {
+		return []byte("/>")
+	}
+	return []byte(">")
+}
+
+// editXML rewrites the start tags of every name element in the file that
+// satisfy match using the edit function. It returns the number of edited elements.
+func editXML(path, name string, match func([]xml.Attr) bool, edit func([]byte) []byte) (int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	els, err := findElements(data, name)
+	if err != nil {
+		return 0, fmt.Errorf("%v is not valid XML: %v", path, err)
+	}
+	c := 0
+	// work backwards so the offsets of earlier elements remain valid
+	for i := len(els) - 1; i >= 0; i-- {
+		e := els[i]
+		if !match(e.attr) {
+			continue
+		}
+		var b bytes.Buffer
+		b.Write(data[:e.start])
+		b.Write(edit(data[e.start:e.end]))
+		b.Write(data[e.end:])
+		data = b.Bytes()
+		c++
+	}
+	if c == 0 {
+		return 0, nil
+	}
+	return c, ioutil.WriteFile(path, data, info.Mode())
+}
+
+// setConnectorPort updates the port of the server.xml connector using the protocol.
+func setConnectorPort(serverXMLPath string, protocol string, newPort int) error {
+	if newPort < 1 || newPort > 65535 {
+		return fmt.Errorf("%v is not a valid port number", newPort)
+	}
+	match := func(attrs []xml.Attr) bool {
+		p, _ := attrValue(attrs, "protocol")
+		return p == protocol
+	}
+	edit := func(tag []byte) []byte {
+		return setAttr(tag, "port", fmt.Sprint(newPort))
+	}
+	c, err := editXML(serverXMLPath, "Connector", match, edit)
+	if err != nil {
+		return err
+	}
+	if c == 0 {
+		return fmt.Errorf("%v has no Connector using the %v protocol", serverXMLPath, protocol)
+	}
+	return nil
+}
+
+// exposedAJP returns true if the connector attributes are of an AJP connector
+// that is not bound to the loopback address, as exploited by CVE-2020-1938 (Ghostcat).
+func exposedAJP(attrs []xml.Attr) bool {
+	p, _ := attrValue(attrs, "protocol")
+	if p != ajpProtocol && !strings.HasPrefix(p, "org.apache.coyote.ajp.") {
+		return false
+	}
+	addr, _ := attrValue(attrs, "address")
+	switch addr {
+	case "127.0.0.1", "::1", "localhost":
+		return false
+	}
+	return true
+}
+
+// findExposedAJP returns the number of AJP connectors in server.xml that are not bound to the loopback address.
+func findExposedAJP(serverXMLPath string) (int, error) {
+	data, err := ioutil.ReadFile(serverXMLPath)
+	if err != nil {
+		return 0, err
+	}
+	els, err := findElements(data, "Connector")
+	if err != nil {
+		return 0, err
+	}
+	c := 0
+	for _, e := range els {
+		if exposedAJP(e.attr) {
+			c++
+		}
+	}
+	return c, nil
+}
+
+// fixAJP binds the exposed AJP connectors of server.xml to 127.0.0.1 and adds a random
+// secret to those without one. It returns the number of fixed connectors.
+func fixAJP(serverXMLPath string) (int, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return 0, err
+	}
+	secret := hex.EncodeToString(b)
+	hasSecret := regexp.MustCompile(`\ssecret\s*=`)
+	edit := func(tag []byte) []byte {
+		tag = setAttr(tag, "address", "127.0.0.1")
+		if !hasSecret.Match(tag) {
+			tag = setAttr(tag, "secret", secret)
+		}
+		return tag
+	}
+	return editXML(serverXMLPath, "Connector", exposedAJP, edit)
+}
+
+// defaultShutdown returns true if the Server attributes use the default shutdown port and command,
+// which lets any local process stop Tomcat.
+func defaultShutdown(attrs []xml.Attr) bool {
+	port, _ := attrValue(attrs, "port")
+	cmd, _ := attrValue(attrs, "shutdown")
+	return port == "8005" && cmd == "SHUTDOWN"
+}
+
+// findDefaultShutdown returns true if server.xml uses the default shutdown port and command.
+func findDefaultShutdown(serverXMLPath string) (bool, error) {
+	data, err := ioutil.ReadFile(serverXMLPath)
+	if err != nil {
+		return false, err
+	}
+	els, err := findElements(data, "Server")
+	if err != nil {
+		return false, err
+	}
+	for _, e := range els {
+		if defaultShutdown(e.attr) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// fixShutdownPort replaces the default shutdown port and command of server.xml with a random
+// unprivileged port and a random 16 character command. The new port is returned, or 0 if
+// server.xml does not use the defaults.
+func fixShutdownPort(serverXMLPath string) (int, error) {
+	port, err := randomPort()
+	if err != nil {
+		return 0, err
+	}
+	cmd, err := randomString(16)
+	if err != nil {
+		return 0, err
+	}
+	edit := func(tag []byte) []byte {
+		return setAttr(setAttr(tag, "port", fmt.Sprint(port)), "shutdown", cmd)
+	}
+	c, err := editXML(serverXMLPath, "Server", defaultShutdown, edit)
+	if err != nil || c == 0 {
+		return 0, err
+	}
+	return port, nil
+}
+
+// randomPort returns a random port from the registered range, 1024 to 49151, that is not
+// a default Tomcat port.
+func randomPort() (int, error) {
+	for {
+		n, err := rand.Int(rand.Reader, big.NewInt(49151-1024+1))
+		if err != nil {
+			return 0, err
+		}
+		port := 1024 + int(n.Int64())
+		switch port {
+		case 8005, 8009, 8080, 8443:
+			continue
+		}
+		return port, nil
+	}
+}
+
+// randomString returns a random string of letters and numbers of the length.
+func randomString(length int) (string, error) {
+	const chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = chars[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// verifyXMLEncoding checks that an XML file declared as UTF-8 only contains valid UTF-8.
+// Files without an encoding declaration are treated as UTF-8.
+func verifyXMLEncoding(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	enc := "UTF-8"
+	scanner := bufio.NewScanner(file)
+	if scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimPrefix(line, "\uFEFF"), "<?xml") {
+			re := regexp.MustCompile(`encoding\s*=\s*["']([^"']+)["']`)
+			if m := re.FindStringSubmatch(line); m != nil {
+				enc = m[1]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !strings.EqualFold(enc, "UTF-8") && !strings.EqualFold(enc, "UTF8") {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if utf8.Valid(data) {
+		return nil
+	}
+	line := 1
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size == 1 {
+			return fmt.Errorf("%v declares %v encoding but has an invalid UTF-8 sequence on line %v at byte offset %v", path, enc, line, i)
+		}
+		if r == '\n' {
+			line++
+		}
+		i += size
+	}
+	return nil
+}
+
+// checkWellFormed returns an error if the XML file is not well-formed, such as a truncated copy.
+// Only the structure is checked, so documents in other encodings are read as is.
+func checkWellFormed(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	d := xml.NewDecoder(bufio.NewReader(file))
+	d.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	root := false
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if _, ok := t.(xml.StartElement); ok {
+			root = true
+		}
+	}
+	if root == false {
+		return fmt.Errorf("The document has no root element")
+	}
+	return nil
+}
+
+// configureProxyScheme sets the scheme, proxyPort and secure attributes of the HTTP/1.1 connector
+// for a Tomcat running behind a reverse proxy. A proxyPort of 0 leaves the attribute unchanged.
+func configureProxyScheme(serverXMLPath, scheme string, proxyPort int, secure bool) error {
+	if scheme != "http" && scheme != "https" {
+		return fmt.Errorf("%q is not a valid proxy scheme, use http or https", scheme)
+	}
+	if proxyPort < 0 || proxyPort > 65535 {
+		return fmt.Errorf("%v is not a valid port number", proxyPort)
+	}
+	match := func(attrs []xml.Attr) bool {
+		p, _ := attrValue(attrs, "protocol")
+		return p == httpProtocol
+	}
+	edit := func(tag []byte) []byte {
+		tag = setAttr(tag, "scheme", scheme)
+		tag = setAttr(tag, "secure", fmt.Sprint(secure))
+		if proxyPort > 0 {
+			tag = setAttr(tag, "proxyPort", fmt.Sprint(proxyPort))
+		}
+		return tag
+	}
+	c, err := editXML(serverXMLPath, "Connector", match, edit)
+	if err != nil {
+		return err
+	}
+	if c == 0 {
+		return fmt.Errorf("%v has no Connector using the %v protocol", serverXMLPath, httpProtocol)
+	}
+	return nil
+}
+
+// accessLogValve is the className of the Tomcat access log valve.
+const accessLogValve = "org.apache.catalina.valves.AccessLogValve"
+
+// configureAccessLog enables the access log valve of the first Host element in server.xml
+// using the log directory, filename prefix, suffix and pattern. A valve is added to the
+// Host when it has none.
+func configureAccessLog(serverXMLPath, logDir, filePrefix, fileSuffix, pattern string) error {
+	match := func(attrs []xml.Attr) bool {
+		c, _ := attrValue(attrs, "className")
+		return c == accessLogValve
+	}
+	edit := func(tag []byte) []byte {
+		tag = setAttr(tag, "directory", logDir)
+		tag = setAttr(tag, "prefix", filePrefix)
+		tag = setAttr(tag, "suffix", fileSuffix)
+		tag = setAttr(tag, "pattern", pattern)
+		return tag
+	}
+	c, err := editXML(serverXMLPath, "Valve", match, edit)
+	if err != nil || c > 0 {
+		return err
+	}
+	info, err := os.Stat(serverXMLPath)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(serverXMLPath)
+	if err != nil {
+		return err
+	}
+	end, err := findEndElement(data, "Host")
+	if err != nil {
+		return fmt.Errorf("%v is not valid XML: %v", serverXMLPath, err)
+	}
+	if end < 0 {
+		return fmt.Errorf("%v has no Host element for the access log", serverXMLPath)
+	}
+	// indent the valve one level deeper than the closing Host tag
+	line := int64(bytes.LastIndexByte(data[:end], '\n') + 1)
+	indent := data[line:end]
+	if len(bytes.TrimSpace(indent)) > 0 {
+		line, indent = end, nil
+	}
+	valve := edit([]byte(fmt.Sprintf("<Valve className=%q />", accessLogValve)))
+	var b bytes.Buffer
+	b.Write(data[:line])
+	b.Write(indent)
+	b.WriteString("  ")
+	b.Write(valve)
+	b.WriteString("\n")
+	b.Write(data[line:])
+	return ioutil.WriteFile(serverXMLPath, b.Bytes(), info.Mode())
+}
+
+// findEndElement returns the byte offset of the first end tag with the local name in data,
+// or -1 if there is none.
+func findEndElement(data []byte, name string) (int64, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		off := d.InputOffset()
+		t, err := d.RawToken()
+		if err == io.EOF {
+			return -1, nil
+		} else if err != nil {
+			return -1, err
+		}
+		if ee, ok := t.(xml.EndElement); ok && ee.Name.Local == name {
+			return off, nil
+		}
+	}
+}
+
+// charmaps are the single byte encodings that can be transcoded to UTF-8.
+var charmaps = map[string]*charmap.Charmap{
+	"iso-8859-1":   charmap.ISO8859_1,
+	"latin1":       charmap.ISO8859_1,
+	"iso-8859-15":  charmap.ISO8859_15,
+	"windows-1252": charmap.Windows1252,
+	"cp1252":       charmap.Windows1252,
+}
+
+// detectFileEncoding guesses the character encoding of a file from its first 512 bytes.
+// A non-UTF-8 encoding named by an XML declaration is trusted, otherwise bytes that are
+// not valid UTF-8 are treated as Windows-1252 when they use its printable 0x80-0x9F
+// range, or else as ISO-8859-1.
+func detectFileEncoding(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	buf = buf[:n]
+	if bytes.HasPrefix(buf, []byte("\xEF\xBB\xBF")) {
+		return "UTF-8", nil
+	}
+	re := regexp.MustCompile(`^<\?xml[^>]*encoding\s*=\s*["']([^"']+)["']`)
+	if m := re.FindSubmatch(buf); m != nil {
+		if enc := string(m[1]); !strings.EqualFold(enc, "UTF-8") && !strings.EqualFold(enc, "UTF8") {
+			return enc, nil
+		}
+	}
+	// ignore a multi-byte character cut short by the end of the buffer
+	for i := len(buf) - 1; i >= 0 && i >= len(buf)-3; i-- {
+		if utf8.RuneStart(buf[i]) {
+			if !utf8.FullRune(buf[i:]) {
+				buf = buf[:i]
+			}
+			break
+		}
+	}
+	if utf8.Valid(buf) {
+		return "UTF-8", nil
+	}
+	for _, b := range buf {
+		if b >= 0x80 && b <= 0x9F {
+			return "windows-1252", nil
+		}
+	}
+	return "ISO-8859-1", nil
+}
+
+// transcodeToUTF8 rewrites the file from the enc encoding to UTF-8 and updates
+// the encoding of any XML declaration to match.
+func transcodeToUTF8(path, enc string) error {
+	cm, ok := charmaps[strings.ToLower(enc)]
+	if !ok {
+		return fmt.Errorf("%v uses the %v encoding which cannot be transcoded to UTF-8", path, enc)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	out, err := cm.NewDecoder().Bytes(data)
+	if err != nil {
+		return fmt.Errorf("%v could not be transcoded from %v: %v", path, enc, err)
+	}
+	re := regexp.MustCompile(`^(<\?xml[^>]*encoding\s*=\s*)("[^"]*"|'[^']*')`)
+	out = re.ReplaceAll(out, []byte(`${1}"UTF-8"`))
+	return ioutil.WriteFile(path, out, info.Mode())
+}
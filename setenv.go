@@ -0,0 +1,173 @@
+// setenv.go - edits to the Tomcat bin/setenv.sh startup script
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	setenv      = "bin/setenv.sh"       // Tomcat startup environment script
+	setenvLocal = "bin/setenv.local.sh" // Site-local overrides sourced by a generated setenv.sh
+)
+
+// gcFlags are the JVM flags of the garbage collectors named by --env-gc.
+var gcFlags = map[string]string{
+	"g1":         "-XX:+UseG1GC",
+	"parallel":   "-XX:+UseParallelGC",
+	"serial":     "-XX:+UseSerialGC",
+	"shenandoah": "-XX:+UseShenandoahGC",
+	"z":          "-XX:+UseZGC",
+}
+
+// heapSize matches a JVM heap size such as 512m or 2g.
+var heapSize = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+
+// envOptions are the JVM options of a generated setenv.sh script.
+type envOptions struct {
+	heapMin string   // initial heap size, -Xms
+	heapMax string   // maximum heap size, -Xmx
+	gc      string   // name of the garbage collector
+	opts    []string // other JVM options
+}
+
+// empty returns true if no options are set and no script should be generated.
+func (e envOptions) empty() bool {
+	return e.heapMin == "" && e.heapMax == "" && e.gc == "" && len(e.opts) == 0
+}
+
+// flags returns the JVM flags of the options.
+func (e envOptions) flags() ([]string, error) {
+	var flags []string
+	for _, h := range []struct{ name, value, flag string }{
+		{"--env-heap-min", e.heapMin, "-Xms"},
+		{"--env-heap-max", e.heapMax, "-Xmx"},
+	} {
+		if h.value == "" {
+			continue
+		}
+		if !heapSize.MatchString(h.value) {
+			return nil, fmt.Errorf("The %v size %q must be a number with an optional k, m or g unit, such as 512m", h.name, h.value)
+		}
+		flags = append(flags, h.flag+h.value)
+	}
+	if e.gc != "" {
+		f, ok := gcFlags[strings.ToLower(e.gc)]
+		if !ok {
+			return nil, fmt.Errorf("The --env-gc collector %q is not g1, parallel, serial, shenandoah or z", e.gc)
+		}
+		flags = append(flags, f)
+	}
+	for _, o := range e.opts {
+		if !strings.HasPrefix(o, "-") {
+			return nil, fmt.Errorf("The --env-opts option %q must begin with a hyphen", o)
+		}
+	}
+	return append(flags, e.opts...), nil
+}
+
+// writeSetenv replaces the setenv.sh script with one that sets CATALINA_OPTS to the options.
+// The script sources the site-local setenv.local.sh last, so its settings take precedence.
+func writeSetenv(setenvPath string, e envOptions) error {
+	flags, err := e.flags()
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# Generated by tomcatupdate, this file is replaced by each update.\n")
+	fmt.Fprintf(&b, "# Site-local settings belong in %v which is kept.\n", filepath.Base(setenvLocal))
+	fmt.Fprintf(&b, "CATALINA_OPTS=\"${CATALINA_OPTS} %v\"\n", strings.Join(flags, " "))
+	fmt.Fprintf(&b, "if [ -r \"${CATALINA_BASE}/%v\" ]; then\n", setenvLocal)
+	fmt.Fprintf(&b, "  . \"${CATALINA_BASE}/%v\"\n", setenvLocal)
+	b.WriteString("fi\n")
+	if err = ioutil.WriteFile(setenvPath, []byte(b.String()), 0755); err != nil {
+		return err
+	}
+	// WriteFile does not change the permissions of an existing file
+	return os.Chmod(setenvPath, 0755)
+}
+
+// setJavaOpts appends the JVM flags to JAVA_OPTS in the setenv.sh script.
+// The script is created when it does not exist.
+func setJavaOpts(setenvPath string, flags []string) error {
+	return appendOpts(setenvPath, "JAVA_OPTS", flags)
+}
+
+// appendOpts appends the flags to the name variable in the setenv.sh script.
+// The last assignment of the variable is updated, otherwise a new assignment is added
+// that preserves any existing value using the ${name} expansion.
+func appendOpts(setenvPath, name string, flags []string) error {
+	for _, f := range flags {
+		if !strings.HasPrefix(f, "-") {
+			return fmt.Errorf("The %v flag %q must begin with a hyphen", name, f)
+		}
+	}
+	mode := os.FileMode(0755)
+	data, err := ioutil.ReadFile(setenvPath)
+	if os.IsNotExist(err) {
+		data = []byte("#!/bin/sh\n")
+	} else if err != nil {
+		return err
+	} else if info, err := os.Stat(setenvPath); err == nil {
+		mode = info.Mode()
+	}
+	expand := fmt.Sprintf("${%v}", name)
+	re := regexp.MustCompile(`^(\s*(?:export\s+)?` + name + `=)"(.*)"\s*$`)
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	found := false
+	for i := len(lines) - 1; i >= 0; i-- {
+		m := re.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+		opts := strings.Fields(m[2])
+		if !strings.Contains(m[2], expand) && !strings.Contains(m[2], "$"+name) {
+			opts = append([]string{expand}, opts...)
+		}
+		for _, f := range flags {
+			if !contains(opts, f) {
+				opts = append(opts, f)
+			}
+		}
+		lines[i] = fmt.Sprintf("%v\"%v\"", m[1], strings.Join(opts, " "))
+		found = true
+		break
+	}
+	if !found {
+		lines = append(lines, fmt.Sprintf("%v=\"%v %v\"", name, expand, strings.Join(flags, " ")))
+	}
+	return ioutil.WriteFile(setenvPath, []byte(strings.Join(lines, "\n")+"\n"), mode)
+}
+
+// contains returns true if s is in the list.
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// keepSetenvLocal copies the setenv.local.sh script of the existing install in oldDir
+// to the new install in newDir, if it exists. True is returned if it was copied.
+func keepSetenvLocal(oldDir, newDir string) (bool, error) {
+	src := filepath.Join(oldDir, setenvLocal)
+	info, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return false, err
+	}
+	return true, ioutil.WriteFile(filepath.Join(newDir, setenvLocal), data, info.Mode())
+}
@@ -0,0 +1,143 @@
+// shutdown.go - stop the running Tomcat before the new install is linked
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shutdownCommand returns the shutdown port and command of the Server element of server.xml.
+func shutdownCommand(serverXMLPath string) (int, string, error) {
+	data, err := ioutil.ReadFile(serverXMLPath)
+	if err != nil {
+		return 0, "", err
+	}
+	els, err := findElements(data, "Server")
+	if err != nil {
+		return 0, "", err
+	}
+	for _, e := range els {
+		v, _ := attrValue(e.attr, "port")
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, "", fmt.Errorf("%v has an invalid shutdown port %q", serverXMLPath, v)
+		}
+		cmd, _ := attrValue(e.attr, "shutdown")
+		return port, cmd, nil
+	}
+	return 0, "", fmt.Errorf("%v has no Server element", serverXMLPath)
+}
+
+// portOpen returns true if a local process accepts connections on the TCP port.
+func portOpen(port int) bool {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// tomcatPID returns the process ID of the running Tomcat, read from the CATALINA_PID file
+// or from the jps list of Java processes.
+func tomcatPID() (int, error) {
+	if name := os.Getenv("CATALINA_PID"); name != "" {
+		if data, err := ioutil.ReadFile(name); err == nil {
+			return strconv.Atoi(strings.TrimSpace(string(data)))
+		}
+	}
+	out, err := exec.Command("jps", "-l").Output()
+	if err != nil {
+		return 0, fmt.Errorf("jps could not list the Java processes: %v", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == "org.apache.catalina.startup.Bootstrap" {
+			return strconv.Atoi(fields[0])
+		}
+	}
+	return 0, fmt.Errorf("jps did not list a running Tomcat")
+}
+
+// shutdownStep reports a step of the Tomcat shutdown.
+func (u *Updater) shutdownStep(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	addEvent("shutdown", msg, true)
+	if u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "\n%v", msg)
+	}
+}
+
+// shutdownTomcat sends the shutdown command of server.xml to the running Tomcat and waits
+// for its HTTP port to close, followed by the --shutdown-grace-period so in-flight requests
+// can complete. A Tomcat that is still running after the --shutdown-timeout is killed.
+func (u *Updater) shutdownTomcat(ctx context.Context, serverXMLPath string) error {
+	port, cmd, err := shutdownCommand(serverXMLPath)
+	if err != nil {
+		return err
+	}
+	httpPort, err := connectorPort(serverXMLPath, httpProtocol)
+	if err != nil {
+		return err
+	}
+	if portOpen(httpPort) == false {
+		u.shutdownStep("Tomcat is not running on port %v", httpPort)
+		return nil
+	}
+	if port < 0 {
+		return fmt.Errorf("The shutdown port of %v is disabled, so Tomcat cannot be stopped", serverXMLPath)
+	}
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("The shutdown command could not be sent to port %v: %v", port, err)
+	}
+	_, err = conn.Write([]byte(cmd))
+	conn.Close()
+	if err != nil {
+		return fmt.Errorf("The shutdown command could not be sent to port %v: %v", port, err)
+	}
+	u.shutdownStep("Sent the shutdown command to port %v", port)
+	for portOpen(httpPort) {
+		if time.Since(start) > u.stopTimeout {
+			pid, err := tomcatPID()
+			if err != nil {
+				return fmt.Errorf("Tomcat did not stop within %v and it could not be killed: %v", u.stopTimeout, err)
+			}
+			if err = exec.Command("kill", "-9", strconv.Itoa(pid)).Run(); err != nil {
+				return fmt.Errorf("Tomcat did not stop within %v and kill -9 %v failed: %v", u.stopTimeout, pid, err)
+			}
+			u.shutdownStep("Killed Tomcat process %v as it did not stop within %v", pid, u.stopTimeout)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	// the grace period is bounded by the time left of the shutdown timeout
+	grace := u.stopGrace
+	if left := u.stopTimeout - time.Since(start); left < grace {
+		grace = left
+	}
+	if grace < 0 {
+		grace = 0
+	}
+	u.shutdownStep("Port %v closed after %v, waiting %v for in-flight requests", httpPort, time.Since(start).Round(time.Second), grace.Round(time.Second))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(grace):
+	}
+	u.shutdownStep("Tomcat stopped")
+	return nil
+}
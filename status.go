@@ -0,0 +1,268 @@
+// status.go - report of the state of an existing Tomcat installation
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+)
+
+// linkStatus is the state of a symbolic link managed by the tool.
+type linkStatus struct {
+	Path   string `json:"path"`
+	Target string `json:"target,omitempty"`
+	State  string `json:"state"` // valid, dangling, missing or not a link
+}
+
+// installStatus is the state of a Tomcat installation.
+type installStatus struct {
+	Dir       string       `json:"dir"`
+	Version   string       `json:"version"`
+	Available string       `json:"available"` // newest release of the series, or the reason it is unknown
+	Port      int          `json:"port"`
+	Running   bool         `json:"running"`
+	Installed *time.Time   `json:"installed,omitempty"` // time the update saved the manifest, nil without a manifest
+	Modified  time.Time    `json:"modified"`
+	Size      uint64       `json:"size"`
+	Links     []linkStatus `json:"links"`
+}
+
+// collectStatus gathers the state of the Tomcat installation in dir
+// and of the links that point to and from it.
+func (u *Updater) collectStatus(dir string, links ...string) (installStatus, error) {
+	s := installStatus{Dir: dir, Port: 8080}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return s, err
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return s, err
+	}
+	s.Modified = info.ModTime()
+	s.Version = strings.TrimPrefix(filepath.Base(resolved), archiveName)
+	if v, err := installedVersion(resolved); err == nil {
+		s.Version = v
+	}
+	if m, err := readManifest(resolved); err == nil {
+		s.Installed = &m.Created
+	}
+	s.Available = "unknown"
+	if v, err := u.latestVersion(s.Version); err == nil {
+		s.Available = v
+	}
+	if port, err := connectorPort(filepath.Join(resolved, conf, "server.xml"), httpProtocol); err == nil {
+		s.Port = port
+	}
+	if c, err := net.DialTimeout("tcp", net.JoinHostPort("localhost", strconv.Itoa(s.Port)), time.Second); err == nil {
+		s.Running = true
+		c.Close()
+	}
+	err = filepath.Walk(resolved, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			s.Size += uint64(info.Size())
+		}
+		return err
+	})
+	if err != nil {
+		return s, err
+	}
+	for _, l := range links {
+		s.Links = append(s.Links, checkLink(l))
+	}
+	return s, nil
+}
+
+// releaseVersion matches the version in the release notes of a Tomcat install.
+var releaseVersion = regexp.MustCompile(`Apache Tomcat Version (\d+\.\d+\.\d+)`)
+
+// installedVersion returns the version of the Tomcat install in dir, such as 8.5.93,
+// found in the manifest saved by the update, its RELEASE-NOTES or RUNNING.txt file,
+// or in the manifest of lib/catalina.jar.
+func installedVersion(dir string) (string, error) {
+	if m, err := readManifest(dir); err == nil && m.Version != "" {
+		return m.Version, nil
+	}
+	for _, name := range []string{"RELEASE-NOTES", "RUNNING.txt"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		if m := releaseVersion.FindSubmatch(data); m != nil {
+			return string(m[1]), nil
+		}
+	}
+	if v, err := jarVersion(filepath.Join(dir, "lib", "catalina.jar")); err == nil {
+		return v, nil
+	}
+	return "", fmt.Errorf("The version of the Tomcat install in %v could not be found in its manifest, RELEASE-NOTES, RUNNING.txt or lib/catalina.jar", dir)
+}
+
+// latestVersion returns the newest release of the series of the installed version,
+// such as 8.5.97 for 8.5.93.
+func (u *Updater) latestVersion(installed string) (string, error) {
+	spl := strings.Split(installed, ".")
+	if len(spl) != 3 {
+		return "", fmt.Errorf("The installed version %q is not a major.minor.point version", installed)
+	}
+	latest, err := u.fetchLatestPointVersion(spl[0], spl[1])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%v.%v.%v", spl[0], spl[1], latest), nil
+}
+
+// jarVersion returns the Implementation-Version of the manifest in the named jar file.
+func jarVersion(name string) (string, error) {
+	r, err := zip.OpenReader(name)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Name != "META-INF/MANIFEST.MF" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		scanner := bufio.NewScanner(rc)
+		for scanner.Scan() {
+			if v := strings.TrimPrefix(scanner.Text(), "Implementation-Version:"); v != scanner.Text() {
+				return strings.TrimSpace(v), nil
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%v has no Implementation-Version", name)
+}
+
+// checkUpdate prints the installed and the latest available versions of the Tomcat
+// install in dir. ExitUpdateAvailable is returned when there is a newer release.
+// Unless the series is given by --major or --minor, the series of the install is checked.
+func (u *Updater) checkUpdate(dir string) (int, error) {
+	installed, err := installedVersion(dir)
+	if err != nil {
+		return ExitCheckFailed, err
+	}
+	spl := strings.Split(installed, ".")
+	if len(spl) != 3 {
+		return ExitCheckFailed, fmt.Errorf("The installed version %q is not a major.minor.point version", installed)
+	}
+	point, err := strconv.Atoi(spl[2])
+	if err != nil {
+		return ExitCheckFailed, fmt.Errorf("The installed version %q is not a major.minor.point version", installed)
+	}
+	major, minor := u.Major, u.Minor
+	if isFlagSet("major") == false && isFlagSet("minor") == false {
+		major, minor = spl[0], spl[1]
+	}
+	latest, err := u.fetchLatestPointVersion(major, minor)
+	if err != nil {
+		return ExitCheckFailed, err
+	}
+	available := fmt.Sprintf("%v.%v.%v", major, minor, latest)
+	if major+"."+minor != spl[0]+"."+spl[1] || latest > point {
+		fmt.Fprintf(u.Stdout, "Installed: %v  Available: %v  (update available)\n", installed, available)
+		return ExitUpdateAvailable, nil
+	}
+	fmt.Fprintf(u.Stdout, "Up to date: %v\n", installed)
+	return ExitOK, nil
+}
+
+// connectorPort returns the port of the server.xml connector using the protocol.
+func connectorPort(serverXMLPath, protocol string) (int, error) {
+	data, err := ioutil.ReadFile(serverXMLPath)
+	if err != nil {
+		return 0, err
+	}
+	els, err := findElements(data, "Connector")
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range els {
+		if p, _ := attrValue(e.attr, "protocol"); p != protocol {
+			continue
+		}
+		v, _ := attrValue(e.attr, "port")
+		return strconv.Atoi(v)
+	}
+	return 0, fmt.Errorf("%v has no Connector using the %v protocol", serverXMLPath, protocol)
+}
+
+// checkPortFree returns an error when the TCP port cannot be listened on,
+// as it is used by another process such as a running Tomcat.
+func checkPortFree(port int) error {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("The port %v is already in use, find the process with: ss -tlnp | grep :%v", port, port)
+	}
+	return l.Close()
+}
+
+// checkLink returns the state of the symbolic link at path.
+func checkLink(path string) linkStatus {
+	l := linkStatus{Path: path}
+	info, err := os.Lstat(path)
+	switch {
+	case err != nil:
+		l.State = "missing"
+	case info.Mode()&os.ModeSymlink == 0:
+		l.State = "not a link"
+	default:
+		l.Target, _ = os.Readlink(path)
+		l.State = "valid"
+		if _, err := os.Stat(path); err != nil {
+			l.State = "dangling"
+		}
+	}
+	return l
+}
+
+// printStatus prints the state of the installation as a table, or as JSON for --json.
+func printStatus(s installStatus) {
+	if jsonOutput == true {
+		b, _ := json.MarshalIndent(s, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+	running := "no"
+	if s.Running {
+		running = "yes"
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Installation\t%v\n", s.Dir)
+	fmt.Fprintf(w, "Version\t%v\n", s.Version)
+	fmt.Fprintf(w, "Available\t%v\n", s.Available)
+	fmt.Fprintf(w, "Running\t%v (port %v)\n", running, s.Port)
+	if s.Installed != nil {
+		fmt.Fprintf(w, "Installed\t%v (%v)\n", s.Installed.Local().Format("2006-01-02 15:04:05"), humanize.Time(*s.Installed))
+	}
+	fmt.Fprintf(w, "Modified\t%v (%v)\n", s.Modified.Format("2006-01-02 15:04:05"), humanize.Time(s.Modified))
+	fmt.Fprintf(w, "Disk usage\t%v\n", humanize.Bytes(s.Size))
+	for _, l := range s.Links {
+		if l.Target != "" {
+			fmt.Fprintf(w, "Symlink\t%v -> %v\t%v\n", l.Path, l.Target, l.State)
+			continue
+		}
+		fmt.Fprintf(w, "Symlink\t%v\t%v\n", l.Path, l.State)
+	}
+	w.Flush()
+}
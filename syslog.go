@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+// syslog.go - write the operation logs of a run to the system logger
+
+package main
+
+import "log/syslog"
+
+// sysLog is the system logger of --syslog.
+var sysLog *syslog.Writer
+
+// openSyslog connects to the system logger using the daemon facility.
+func openSyslog() error {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "tomcatupdate")
+	if err != nil {
+		return err
+	}
+	sysLog = w
+	return nil
+}
+
+// syslogMsg sends the message to the system logger with the priority of the
+// level, ERROR, WARN, INFO or DEBUG. It does nothing unless --syslog is set.
+func syslogMsg(level, msg string) {
+	if sysLog == nil {
+		return
+	}
+	switch level {
+	case "ERROR":
+		sysLog.Err(msg)
+	case "WARN":
+		sysLog.Warning(msg)
+	case "DEBUG":
+		sysLog.Debug(msg)
+	default:
+		sysLog.Info(msg)
+	}
+}
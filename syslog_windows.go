@@ -0,0 +1,12 @@
+// syslog_windows.go - write the operation logs of a run to the system logger
+
+package main
+
+// openSyslog is not supported on Windows, it only lets the tool build
+// so that init can report that Windows is not supported.
+func openSyslog() error {
+	return nil
+}
+
+// syslogMsg is not supported on Windows.
+func syslogMsg(level, msg string) {}
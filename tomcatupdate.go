@@ -11,48 +11,360 @@ package main
 import (
 	"archive/tar"
 	"bufio"
+	"bytes"
 	"compress/gzip"
-	"crypto"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/sha512"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/phayes/permbits"
+	"golang.org/x/term"
 )
 
 const (
-	ver1        = "8"                                                                          // Tomcat major version
-	ver2        = "5"                                                                          // Tomcat minor version
-	userID      = 0                                                                            // `tomcat` user ID (cat /etc/passwd)
-	groupID     = 0                                                                            // `tomcat` group ID (cat /etc/group)
-	prefix      = "."                                                                          // Text to separate results from other feedback
-	urlTemplate = "https://www.apache.org/dist/tomcat/tomcat-?/v?/bin/?apache-tomcat-?.tar.gz" // Must always point to apache.org and not a host mirror
+	prefix      = "."                                                                                 // Text to separate results from other feedback
+	urlBase     = "https://www.apache.org/"                                                           // Must always point to apache.org and not a host mirror
+	urlPath     = "dist/tomcat/tomcat-{{.Major}}/v{{.Major}}.{{.Minor}}.{{.Patch}}/bin/{{.Filename}}" // Template of the archive path on urlBase
+	archiveName = "apache-tomcat-"                                                                    // Archive and directory name prefix
 )
 
+// Errors returned by archive extraction
 var (
-	conf      = "conf"         // Tomcat configuration sub-directory
-	logErrs   = false          // Log errors with a timestamp
-	quiet     = false          // No terminal output except for errors
-	tomcatDir = "/opt/tomcat8" // Location of Tomcat installation
-	verbose   = false          // Output each archive item handled
-	ver3      = -1             // Tomcat point version
-
-	configs = []string{"logging.properties", "server.xml", "web.xml"}                                                                      // Tomcat configurations to migrate
-	ignored = []string{"LICENSE", "NOTICE", "webapps/docs", "webapps/examples", "webapps/host-manager", "webapps/manager", "webapps/ROOT"} // Ignore these directories and files when extracting from tarball
-	urlPage = fmt.Sprintf("https://tomcat.apache.org/download-%v0.cgi", ver1)                                                              // Link to Apache Tomcat download page
+	ErrTooManyEntries  = errors.New("The tarball has too many entries")
+	ErrTooManySymlinks = errors.New("The tarball has too many symbolic links")
+	ErrContentRejected = errors.New("The tarball has files rejected by the extract filter")
+	ErrOutsideTarget   = errors.New("The tarball has an item outside of the extraction directory")
+	ErrChecksum        = errors.New("The download failed as its checksum does not match the expected checksum")
+	ErrSignature       = errors.New("The download failed as its PGP signature could not be verified")
 )
 
+// ErrLocked is returned when another run holds the lock of the Tomcat install.
+var ErrLocked = errors.New("Another tomcatupdate is already running")
+
+// ErrLintFailed is returned when the configuration linter rejected one or more files.
+var ErrLintFailed = errors.New("The configuration linter rejected files")
+
+// ErrFreeSpace is returned by freeSpace on platforms that cannot report the free space.
+var ErrFreeSpace = errors.New("The free space of a directory cannot be checked on this platform")
+
+// Exit codes
+const (
+	ExitOK              = 0 // Successful completion
+	ExitError           = 1 // An error caused the tool to abort
+	ExitUpdateAvailable = 1 // The --check mode found a newer release
+	ExitVerifyFailed    = 1 // The --verify mode found files that differ from the manifest
+	ExitCheckFailed     = 2 // An error caused the --check mode to abort
+	ExitContentRejected = 3 // The extract filter command rejected files from the archive
+	ExitLintFailed      = 4 // The configuration linter rejected one or more files
+	ExitLocked          = 5 // Another run holds the lock of the Tomcat install
+)
+
+var (
+	conf          = "conf"         // Tomcat configuration sub-directory
+	confLinter    = ""             // Command to validate each configuration before it is migrated
+	detectEnc     = false          // Detect the character encoding of configurations
+	distPath      = urlPath        // Template of the archive path or URL
+	distribution  = "apache"       // Tomcat distribution profile
+	dryRun        = false          // Print the changes without modifying the filesystem
+	errCode       = ExitError      // Exit code of errors, --check uses ExitCheckFailed
+	filterCmd     = ""             // Command to validate each file extracted from the tarball
+	groupID       = 0              // `tomcat` group ID (cat /etc/group)
+	httpPort      = 0              // Replacement port for the HTTP connector
+	httpsPort     = 0              // Replacement port for the HTTPS connector
+	jsonOutput    = false          // Only output a JSON array of the events of the run
+	lineEnding    = "lf"           // Line endings of migrated configurations, lf, crlf or preserve
+	linkName      = "tomcat8"      // Name of the version-neutral symlink to the new install
+	lintFailures  = 0              // Number of configurations rejected by the linter
+	logErrs       = false          // Log errors with a timestamp
+	minFreeMB     = 100            // Free space in MB required on top of the archive size
+	phase         = "setup"        // Current step of the update, reported by --summary
+	pidFile       = ""             // Save the process ID to this file
+	placeholders  = false          // Check configurations for unresolved placeholders
+	postHook      = ""             // Shell command run after the update is complete
+	preHook       = ""             // Shell command run before the archive is downloaded
+	quietErrs     = false          // No terminal output including errors
+	recovery      = false          // Only extract files missing from an earlier, interrupted run
+	release       = ""             // Tomcat version of the run, reported by --summary
+	retries       = 3              // Retries of downloads that fail with a network or server error
+	showDiff      = false          // Print the differences of each configuration before it is replaced
+	skipChown     = false          // Only change the ownership of files with a different owner
+	skipInvalid   = false          // Skip symlinks with invalid targets instead of aborting
+	sortProps     = false          // Sort the entries of migrated .properties configurations
+	stripDebug    = false          // Replace the debug log levels of the migrated logging.properties
+	summary       = false          // Only output a one line JSON summary of the run
+	tomcatDir     = "/opt/tomcat8" // Location of Tomcat installation
+	transcode     = false          // Transcode non-UTF-8 configurations to UTF-8
+	userID        = 0              // `tomcat` user ID (cat /etc/passwd)
+	validateLinks = false          // Check symlink targets exist before creating them
+	webapps       = "webapps"      // Tomcat web applications sub-directory
+
+	maxArchiveSize  uint64 = 50000000   // Largest archive size permitted for download
+	maxConfSize     uint64 = 10000000   // Largest configuration size permitted for migration
+	maxLogSize      uint64 = 10000000   // Size of the log file that causes it to be rotated
+	minTempSpace    uint64 = 0          // Smallest free space required in the Tomcat temp directory
+	tarEntryLimit          = 50000      // Most entries permitted in a tarball
+	tarSymlinkLimit        = 10         // Most symbolic links permitted in a tarball
+	extractWorkers         = 1          // Goroutines that write the files extracted from a tarball
+	started                = time.Now() // Time the tool was run
+
+	symlinks    = []SymlinkPair{}                                                                                                              // Symlinks created within the new install
+	ignored     = []string{"LICENSE", "NOTICE", "webapps/docs", "webapps/examples", "webapps/host-manager", "webapps/manager", "webapps/ROOT"} // Ignore these directories and files when extracting from tarball
+	allowedApps = list{"ROOT"}                                                                                                                 // Web applications permitted in an existing install
+)
+
+// defaultConfigs are the Tomcat configurations migrated unless --reset-migrate is set.
+var defaultConfigs = []string{"logging.properties", "server.xml", "web.xml"}
+
+// tomcatSeries are the known major.minor release series of Tomcat, oldest first.
+var tomcatSeries = []string{"8.5", "9.0", "10.0", "10.1", "11.0"}
+
+// newestMinor returns the minor version of the newest known series of the major version.
+func newestMinor(major string) string {
+	minor := ""
+	for _, s := range tomcatSeries {
+		if v := strings.SplitN(s, ".", 2); v[0] == major {
+			minor = v[1]
+		}
+	}
+	return minor
+}
+
+// downloadPage returns the link to the Apache Tomcat download page of the major version.
+func downloadPage(major string) string {
+	if m, _ := strconv.Atoi(major); m >= 10 {
+		return fmt.Sprintf("https://tomcat.apache.org/download-%v.cgi", major)
+	}
+	return fmt.Sprintf("https://tomcat.apache.org/download-%v0.cgi", major)
+}
+
+// list is a command line flag that can be repeated to collect multiple values.
+type list []string
+
+func (l *list) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *list) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// runSummary is the result of a run printed by --summary.
+type runSummary struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Phase     string `json:"phase,omitempty"`
+	Version   string `json:"version"`
+	Duration  int64  `json:"duration_ms"`
+	Host      string `json:"host"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Event is an operation of the run printed by --json.
+type Event struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	OK     string `json:"ok"`
+	File   string `json:"file,omitempty"`  // file of a multi-file operation
+	Index  int    `json:"index,omitempty"` // position of the file in the operation, from 1
+	Total  int    `json:"total,omitempty"` // number of files in the operation
+}
+
+// events are the operations of the run printed by --json.
+var events []Event
+
+// fileLog writes the operations of the run to the --log-file.
+var fileLog *log.Logger
+
+// Updater updates a Tomcat install using its configuration and dependencies.
+type Updater struct {
+	TomcatDir    string       // Location of Tomcat installation
+	Quiet        bool         // No terminal output except for errors
+	Verbose      bool         // Output each archive item handled
+	LogErrors    bool         // Log errors with a timestamp
+	Major        string       // Tomcat major version
+	Minor        string       // Tomcat minor version
+	PointVersion int          // Tomcat point version, -1 to fetch or ask for the latest
+	HTTPClient   *http.Client // HTTP client used for all downloads
+	Stdout       io.Writer    // Terminal output
+	Stderr       io.Writer    // Warnings and download progress
+
+	runOptions
+}
+
+// runOptions are the command line settings of the update steps.
+type runOptions struct {
+	accessLog      bool            // Add or enable the access log valve
+	accessPattern  string          // Format of the access log entries
+	allowExtra     bool            // Continue when unexpected web applications are found
+	allowHolders   bool            // Migrate configurations that contain placeholders
+	backupApps     bool            // Exclude the webapps directory from the install backup
+	backupDir      string          // Directory of the install backups
+	backupLogs     bool            // Include the logs in the install backup
+	builder        URLBuilder      // Archive and checksum URLs of the distribution
+	checkPerms     bool            // Check the directories used by the update are writable
+	checkPort      int             // HTTP port checked to be free before the install is completed
+	cleanApps      bool            // Abort if the existing install has unexpected web applications
+	clearWork      bool            // Remove the compiled JSPs from the work directory
+	confBackup     bool            // Save the existing configurations before migration
+	confBackupDir  string          // Directory of the configuration backups
+	configs        []string        // Tomcat configurations to migrate
+	confGID        int             // Group ID of the migrated configurations, -1 to use groupID
+	confMode       uint64          // Expected permissions of the configurations
+	confUID        int             // User ID of the migrated configurations, -1 to use userID
+	distKeys       string          // KEYS file of the signers of a vmware, redhat or custom distribution
+	env            envOptions      // JVM options of a generated setenv.sh
+	downloadDir    string          // Directory of the cached archives
+	dryRun         bool            // Print the changes without modifying the filesystem
+	extractDir     string          // Directory the archive is extracted to
+	extraFiles     list            // Local directories copied into the new install
+	extraOverwrite bool            // Replace existing files with the extra files
+	fixAJP         bool            // Bind exposed AJP connectors to the loopback address
+	fixShutdown    bool            // Replace the default shutdown port and command
+	fixWritable    bool            // Remove the world-writable permission from extracted files
+	force          bool            // Install the version even when it is already installed
+	healthMethod   string          // HTTP method of the health endpoint polls, GET or HEAD
+	healthURL      string          // URL polled after the update until Tomcat responds
+	install        bool            // Install to a new directory instead of updating
+	javaHome       string          // Java runtime checked for compatibility, otherwise JAVA_HOME or the PATH is used
+	jvmFlags       list            // JVM flags appended to JAVA_OPTS
+	keep           int             // Number of configuration backups kept by rotation
+	keepArchive    bool            // Keep the downloaded and intermediate archives
+	noChmod        bool            // Leave the permissions of the new install unchanged
+	noChown        bool            // Leave the ownership of the new install unchanged
+	noStream       bool            // Save the archive to a local file before extracting it
+	noSymlinks     bool            // Skip the symlinks, which are created by configuration management
+	permsReport    bool            // List configurations without the expected permissions
+	portRequired   bool            // Abort instead of warning when the checked port is in use
+	purgeWork      bool            // Recreate the work directory of the new install
+	postExtract    string          // Shell command run after extraction
+	proxyPort      int             // Port of the reverse proxy
+	proxyScheme    string          // Scheme of the reverse proxy
+	proxySecure    bool            // Mark the HTTP/1.1 connector as secure
+	reload         bool            // Reload instead of restart the service
+	rotate         bool            // Remove older configuration backups
+	service        string          // systemd unit to restart after the update
+	sha256File     string          // Local checksum file of the archive
+	shutdown       context.Context // Cancelled when SIGINT or SIGTERM is received
+	skipChown      bool            // Only change the ownership of files with a different owner
+	skipGPG        bool            // Do not verify the PGP signature of the archive
+	stopGrace      time.Duration   // Wait after the HTTP port of the stopped Tomcat closes
+	stopTimeout    time.Duration   // Time to stop Tomcat before it is killed
+	stopTomcat     bool            // Stop the running Tomcat before the new install is linked
+	strict         bool            // Abort instead of skipping problem files
+	tempDir        string          // Directory for java.io.tmpdir
+	verifyEnc      bool            // Check the XML configurations are valid UTF-8
+	warnWritable   bool            // List world-writable extracted files
+	workDir        string          // Non-standard Tomcat work directory
+}
+
+// loggingTransport logs the requests and responses of the HTTP client.
+// Bodies and Set-Cookie headers are never logged.
+type loggingTransport struct {
+	base     http.RoundTripper
+	requests bool // log the method, URL, status and duration
+	headers  bool // log the response headers
+}
+
+func (t loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	ms := time.Since(start).Milliseconds()
+	if err != nil {
+		if t.requests {
+			log.Printf("INFO: method=%v url=%v error=%q duration_ms=%v", req.Method, req.URL, err, ms)
+		}
+		return resp, err
+	}
+	if t.requests {
+		log.Printf("INFO: method=%v url=%v status=%v content_length=%v duration_ms=%v", req.Method, req.URL, resp.StatusCode, resp.ContentLength, ms)
+	}
+	if t.headers {
+		keys := make([]string, 0, len(resp.Header))
+		for k := range resp.Header {
+			if k != "Set-Cookie" {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range resp.Header[k] {
+				log.Printf("INFO: < %v: %v", k, v)
+			}
+		}
+	}
+	return resp, err
+}
+
+// isFlagSet returns true if the named flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// printExportEnv prints the value of every flag as a TOMCATUPDATE_ shell export.
+// Passwords are masked.
+func printExportEnv() {
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "export-env" {
+			return
+		}
+		key := "TOMCATUPDATE_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		val := f.Value.String()
+		if strings.HasSuffix(f.Name, "password") && val != "" {
+			val = "****"
+		}
+		fmt.Printf("export %v='%v'\n", key, strings.ReplaceAll(val, "'", `'\''`))
+	})
+}
+
+// PermReport compares the actual and expected permissions of a file.
+type PermReport struct {
+	Path         string
+	ActualMode   os.FileMode
+	ExpectedMode os.FileMode
+	IsCorrect    bool
+}
+
 func init() {
 	if runtime.GOOS == "windows" {
 		err := fmt.Errorf("This application is not compatible with Microsoft Windows")
@@ -62,423 +374,3293 @@ func init() {
 
 func main() {
 	// handle command line options
+	var allowedFlag, jvmFlags, extraFlags, ignoreFlags, migrateFlags, symlinkFlags list
+	allowExtraFlag := flag.Bool("allow-extra-webapps", false, fmt.Sprintf("continue when -require-clean-webapps finds unexpected web applications"))
+	flag.Var(&allowedFlag, "allowed-webapp", fmt.Sprintf("name of a web application permitted by -require-clean-webapps, can be repeated (default %v)", allowedApps.String()))
+	filterCmdFlag := flag.String("extract-filter-cmd", filterCmd, fmt.Sprintf("command to validate each extracted file, it is given the path of a temporary copy"))
+	fixAJPFlag := flag.Bool("fix-ajp", false, fmt.Sprintf("bind the AJP connectors of the migrated server.xml to 127.0.0.1 and add a random secret"))
+	fixShutdownFlag := flag.Bool("fix-shutdown-port", false, fmt.Sprintf("replace the default shutdown port 8005 and command of the migrated server.xml with random values"))
+	fixWritableFlag := flag.Bool("fix-world-writable", false, fmt.Sprintf("remove the world-writable permission from extracted files and directories"))
+	warnWritableFlag := flag.Bool("warn-world-writable", true, fmt.Sprintf("list any world-writable extracted files and directories"))
+	userFlag := flag.String("user", "", fmt.Sprintf("name or ID of the user to own the new install, such as tomcat8 (default %v)", userID))
+	groupFlag := flag.String("group", "", fmt.Sprintf("name or ID of the group to own the new install, such as tomcat8 (default %v)", groupID))
+	confUIDFlag := flag.Int("conf-owner-user", -1, fmt.Sprintf("user ID to own the migrated configurations instead of %v", userID))
+	confGIDFlag := flag.Int("conf-owner-group", -1, fmt.Sprintf("group ID to own the migrated configurations instead of %v", groupID))
+	detectEncFlag := flag.Bool("detect-encoding", detectEnc, fmt.Sprintf("detect the character encoding of the configurations and allow those that are not UTF-8"))
+	transcodeFlag := flag.Bool("transcode-to-utf8", transcode, fmt.Sprintf("convert migrated configurations found by -detect-encoding to UTF-8"))
+	verifyEncFlag := flag.Bool("verify-xml-encoding", false, fmt.Sprintf("check the XML configurations are valid UTF-8 before they are migrated"))
+	httpPortFlag := flag.Int("http-port", httpPort, fmt.Sprintf("replace the port of the HTTP/1.1 connector in server.xml"))
+	httpsPortFlag := flag.Int("https-port", httpsPort, fmt.Sprintf("replace the port of the HTTPS connector in server.xml"))
+	pinFlag := flag.String("pin-cert-hash", "", fmt.Sprintf("SHA-256 hex hash of the download server's public key to pin"))
+	linkNameFlag := flag.String("link-name", linkName, fmt.Sprintf("name of the version-neutral symlink to the new install"))
+	skipGPGFlag := flag.Bool("skip-gpg", false, fmt.Sprintf("do not verify the PGP signature of the archive"))
+	proxyFlag := flag.String("proxy", "", fmt.Sprintf("proxy URL for all downloads, otherwise the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used"))
+	retriesFlag := flag.Int("retries", retries, fmt.Sprintf("number of times to retry downloads that fail with a network or server error"))
+	connectTimeoutFlag := flag.Duration("connect-timeout", 30*time.Second, fmt.Sprintf("time limit to connect to the download server, 0 is unlimited"))
+	downloadTimeoutFlag := flag.Duration("download-timeout", 0, fmt.Sprintf("time limit of each download including the transfer of the file, 0 is unlimited"))
+	sha256FileFlag := flag.String("sha256-file", "", fmt.Sprintf("verify the archive using a local .sha256 checksum file instead of fetching the checksum"))
+	dryRunFlag := flag.Bool("dry-run", dryRun, fmt.Sprintf("print the changes the update would make without modifying the filesystem"))
+	configFlag := flag.String("config", "", fmt.Sprintf("TOML configuration file of settings, overridden by any flags"))
+	forceFlag := flag.Bool("force", false, fmt.Sprintf("update even when the requested version is already installed"))
+	installFlag := flag.Bool("install", false, fmt.Sprintf("install Tomcat to a new directory instead of updating an existing install"))
+	verifyFlag := flag.Bool("verify", false, fmt.Sprintf("compare the files of the -dir install to the manifest saved by the update and exit, with 0 when they match or 1 when they differ"))
+	checkFlag := flag.Bool("check", false, fmt.Sprintf("print the installed and the latest available versions and exit, with 0 when up to date, 1 when an update is available or 2 on error"))
+	exportEnvFlag := flag.Bool("export-env", false, fmt.Sprintf("print the effective configuration as shell exports and exit"))
+	printPinFlag := flag.Bool("print-cert-hash", false, fmt.Sprintf("print the SHA-256 hash of the download server's public key and exit"))
+	distPathFlag := flag.String("apache-dist-path", distPath, fmt.Sprintf("template of the archive path on %v or a complete URL, using {{.Major}} {{.Minor}} {{.Patch}} {{.Filename}}", urlBase))
+	distFlag := flag.String("distribution", distribution, fmt.Sprintf("Tomcat distribution to download, apache, vmware, redhat or custom"))
+	baseURLFlag := flag.String("base-url", "", fmt.Sprintf("URL of an Apache mirror to download the archive from instead of %v, the checksum is still fetched from apache.org", apacheDist))
+	distURLFlag := flag.String("dist-url", "", fmt.Sprintf("archive URL template for the vmware, redhat and custom distributions, using {{.Major}} {{.Minor}} {{.Patch}} {{.Filename}}"))
+	distKeysFlag := flag.String("dist-keys-url", "", fmt.Sprintf("KEYS file URL of the signers of the vmware, redhat and custom distributions, the PGP signature is not verified without it"))
+	distUserFlag := flag.String("dist-username", "", fmt.Sprintf("username for authenticated distribution downloads"))
+	distPassFlag := flag.String("dist-password", "", fmt.Sprintf("password for authenticated distribution downloads"))
+	assumeYesFlag := flag.Bool("assume-yes", false, fmt.Sprintf("answer yes to any confirmation prompts"))
+	cleanupFlag := flag.Bool("workspace-cleanup", false, fmt.Sprintf("remove the archives, backups and reports created by the tool in the work, -download-dir, -extract-dir and -backup-dir directories and exit"))
+	lineEndingFlag := flag.String("conf-line-ending", lineEnding, fmt.Sprintf("line endings of the migrated configurations, lf, crlf or preserve"))
+	maxConfFlag := flag.String("max-conf-size", humanize.Bytes(maxConfSize), fmt.Sprintf("skip the migration of configurations larger than this size"))
+	linterFlag := flag.String("conf-linter", confLinter, fmt.Sprintf("command to validate each existing configuration before it is migrated"))
+	stripDebugFlag := flag.Bool("conf-strip-debug", stripDebug, fmt.Sprintf("replace the FINE, FINER, FINEST and ALL levels of the migrated logging.properties with INFO"))
+	sortPropsFlag := flag.Bool("conf-sort-properties", sortProps, fmt.Sprintf("sort the entries of migrated .properties configurations by key"))
+	diffFlag := flag.Bool("diff", showDiff, fmt.Sprintf("print the differences of each configuration before it is replaced, unless -quiet"))
+	strictFlag := flag.Bool("strict", false, fmt.Sprintf("abort instead of skipping configurations that fail a check"))
+	minFreeFlag := flag.Int("min-free-mb", minFreeMB, fmt.Sprintf("free space in MB required on the file system of -dir in addition to the size of the archive"))
+	maxSizeFlag := flag.String("max-archive-size", humanize.Bytes(maxArchiveSize), fmt.Sprintf("abort downloads of archives larger than this size"))
+	flag.Var(&extraFlags, "copy-extra-files", fmt.Sprintf("copy the files of a local directory into the new install using srcDir:dstSubDir, can be repeated"))
+	extraOverFlag := flag.Bool("extra-files-overwrite", true, fmt.Sprintf("replace existing files in the new install with those of -copy-extra-files"))
+	skipChownFlag := flag.Bool("skip-chown-if-correct", skipChown, fmt.Sprintf("only change the ownership of files not already owned by the Tomcat user and group"))
+	tempDirFlag := flag.String("tomcat-temp-dir", "", fmt.Sprintf("directory for java.io.tmpdir, set in CATALINA_OPTS of %v", setenv))
+	minTempFlag := flag.String("min-temp-space", humanize.Bytes(minTempSpace), fmt.Sprintf("smallest free space required in the -tomcat-temp-dir directory"))
+	envHeapMinFlag := flag.String("env-heap-min", "", fmt.Sprintf("initial JVM heap size, such as 512m, in a generated %v", setenv))
+	envHeapMaxFlag := flag.String("env-heap-max", "", fmt.Sprintf("maximum JVM heap size, such as 2g, in a generated %v", setenv))
+	envGCFlag := flag.String("env-gc", "", fmt.Sprintf("garbage collector in a generated %v, g1, parallel, serial, shenandoah or z", setenv))
+	envOptsFlag := flag.String("env-opts", "", fmt.Sprintf("other JVM options in a generated %v, such as \"-Dfile.encoding=UTF-8 -Djava.awt.headless=true\"", setenv))
+	javaHomeFlag := flag.String("java-home", "", fmt.Sprintf("Java install to check is compatible with the Tomcat release, instead of JAVA_HOME or the java command"))
+	flag.Var(&jvmFlags, "jvm-flag", fmt.Sprintf("JVM flag to append to JAVA_OPTS in %v, can be repeated", setenv))
+	ifaceFlag := flag.String("network-interface", "", fmt.Sprintf("name of the network interface to use for downloads, such as eth1"))
+	accessLogFlag := flag.Bool("enable-access-log", false, fmt.Sprintf("add or enable the access log valve in the migrated server.xml"))
+	accessPatternFlag := flag.String("access-log-pattern", "combined", fmt.Sprintf("format of the access log entries set by -enable-access-log"))
+	logHeadFlag := flag.Bool("log-download-headers", false, fmt.Sprintf("log the response headers of all HTTP requests"))
+	userAgentFlag := flag.String("user-agent", "", fmt.Sprintf("User-Agent header of all HTTP requests, such as \"TomcatUpdater/1.0 ops@example.com\""))
+	logReqFlag := flag.Bool("log-requests", false, fmt.Sprintf("log the method, URL, status and duration of all HTTP requests"))
 	logErrsFlag := flag.Bool("log", logErrs, fmt.Sprintf("log any errors with timestamps"))
-	tomcatDirFlag := flag.String("dir", tomcatDir, fmt.Sprintf("path to existing Tomcat %v.%v install", ver1, ver2))
-	quietFlag := flag.Bool("quiet", quiet, fmt.Sprintf("suppress terminal output"))
-	verFlag := flag.Int("ver", -1, fmt.Sprintf("version of Tomcat %v.%v.* to download", ver1, ver2))
+	logFileFlag := flag.String("log-file", "", fmt.Sprintf("append the operations of the run and any errors to this log file, in addition to stderr"))
+	syslogFlag := flag.Bool("syslog", false, fmt.Sprintf("send the operations of the run and any errors to the system logger, with -verbose the output is sent at the debug priority"))
+	maxLogFlag := flag.String("log-max-size", humanize.Bytes(maxLogSize), fmt.Sprintf("rotate the -log-file when it is larger than this size, 0 to never rotate"))
+	holdersFlag := flag.Bool("conf-placeholder", placeholders, fmt.Sprintf("abort if migrated configurations contain unresolved placeholders such as ${NAME} or @@NAME@@"))
+	allowHoldersFlag := flag.Bool("allow-placeholders", false, fmt.Sprintf("only warn when -conf-placeholder finds placeholders"))
+	proxySchemeFlag := flag.String("proxy-scheme", "", fmt.Sprintf("scheme of a reverse proxy in front of the HTTP/1.1 connector, http or https"))
+	proxyPortFlag := flag.Int("proxy-port", 0, fmt.Sprintf("port of the reverse proxy, defaults to 443 for the https scheme"))
+	proxySecureFlag := flag.Bool("proxy-secure", false, fmt.Sprintf("mark the HTTP/1.1 connector as secure, defaults to true for the https scheme"))
+	checkPortFlag := flag.Int("check-port", 8080, fmt.Sprintf("check the HTTP port is not in use before completing the install, defaults to the port of the migrated server.xml"))
+	portRequiredFlag := flag.Bool("port-required", false, fmt.Sprintf("abort instead of warning when the -check-port is in use, implies -check-port"))
+	checkPermsFlag := flag.Bool("check-permissions", false, fmt.Sprintf("check the Tomcat, configuration, work and temporary directories are writable before starting"))
+	confPermsFlag := flag.String("conf-permissions", "0640", fmt.Sprintf("expected octal permissions of configuration files for -conf-permissions-report"))
+	permsReportFlag := flag.Bool("conf-permissions-report", false, fmt.Sprintf("list configuration files that do not have the -conf-permissions before and after migration"))
+	clearWorkFlag := flag.Bool("clear-work-dir", false, fmt.Sprintf("remove the compiled JSPs from the Tomcat work directory after extraction"))
+	purgeWorkFlag := flag.Bool("purge-work", false, fmt.Sprintf("delete and recreate the work directory of the new install after extraction"))
+	workDirFlag := flag.String("tomcat-work-dir", "", fmt.Sprintf("path of a non-standard Tomcat work directory set by workDir in server.xml"))
+	backupDirFlag := flag.String("backup-dir", "", fmt.Sprintf("directory to save a backup of the existing Tomcat install before updating"))
+	serviceFlag := flag.String("service", "", fmt.Sprintf("systemd unit to restart after the update, such as tomcat8.service"))
+	reloadFlag := flag.Bool("reload", false, fmt.Sprintf("reload instead of restart the -service unit"))
+	stopTomcatFlag := flag.Bool("stop-tomcat", false, fmt.Sprintf("stop the running Tomcat of -dir with the shutdown port of its server.xml before the new install is linked"))
+	shutdownTimeoutFlag := flag.Duration("shutdown-timeout", 60*time.Second, fmt.Sprintf("time -stop-tomcat waits for Tomcat to stop before it is killed"))
+	shutdownGraceFlag := flag.Duration("shutdown-grace-period", 5*time.Second, fmt.Sprintf("time -stop-tomcat waits after the HTTP port closes for in-flight requests to complete"))
+	healthURLFlag := flag.String("health-endpoint", "", fmt.Sprintf("URL polled after the update until Tomcat responds, such as http://localhost:8080/"))
+	healthMethodFlag := flag.String("health-endpoint-method", "HEAD", fmt.Sprintf("HTTP method of the -health-endpoint polls, GET or HEAD"))
+	rollbackFlag := flag.Bool("rollback", false, fmt.Sprintf("restore the most recent -backup-dir backup to the Tomcat install and exit"))
+	backupLogsFlag := flag.Bool("backup-include-logs", false, fmt.Sprintf("include the .log and .txt files of the logs directory in the -backup-dir backup"))
+	backupAppsFlag := flag.Bool("backup-exclude-webapps", false, fmt.Sprintf("exclude the webapps directory from the -backup-dir backup"))
+	confBackupFlag := flag.Bool("create-conf-backup", false, fmt.Sprintf("save the existing configurations to a timestamped tarball before migration"))
+	confBackupDirFlag := flag.String("conf-backup-dir", ".", fmt.Sprintf("directory to save the configuration backups"))
+	rotateFlag := flag.Bool("auto-conf-backup-rotation", false, fmt.Sprintf("remove older configuration backups after a successful update"))
+	keepFlag := flag.Int("conf-backup-keep", 5, fmt.Sprintf("number of configuration backups kept by -auto-conf-backup-rotation"))
+	postExtractFlag := flag.String("post-extract-script", "", fmt.Sprintf("shell command to run after extraction and before the configurations are migrated"))
+	flag.Var(&symlinkFlags, "symlink", fmt.Sprintf("symlink to create in the new install using target:link, such as /var/www/app:webapps/ROOT, can be repeated"))
+	validateLinksFlag := flag.Bool("validate-symlink-targets", false, fmt.Sprintf("check symlink targets exist and are readable before creating the links"))
+	skipInvalidFlag := flag.Bool("skip-invalid-symlinks", false, fmt.Sprintf("skip symlinks that fail -validate-symlink-targets instead of aborting"))
+	pidFileFlag := flag.String("pid-file", pidFile, fmt.Sprintf("save the process ID to this file for signal handling"))
+	tomcatDirFlag := flag.String("dir", tomcatDir, fmt.Sprintf("path to existing Tomcat install"))
+	keepArchiveFlag := flag.Bool("keep-archive", false, fmt.Sprintf("keep the downloaded .tar.gz archive after the run"))
+	downloadDirFlag := flag.String("download-dir", "", fmt.Sprintf("directory to cache the downloaded archives and checksums, such as a shared NFS mount"))
+	extractDirFlag := flag.String("extract-dir", "", fmt.Sprintf("directory to extract the archive to instead of the working directory, such as /opt/staging"))
+	workersFlag := flag.Int("extract-workers", runtime.NumCPU(), fmt.Sprintf("number of files written at the same time during extraction, 1 extracts the files in archive order"))
+	entryLimitFlag := flag.Int("tar-entry-limit", tarEntryLimit, fmt.Sprintf("abort extraction of tarballs with more entries"))
+	linkLimitFlag := flag.Int("tar-symlink-limit", tarSymlinkLimit, fmt.Sprintf("abort extraction of tarballs with more symbolic links"))
+	flag.Var(&ignoreFlags, "ignore", fmt.Sprintf("path within the archive to skip when extracting, such as webapps/examples, can be repeated (default %v)", strings.Join(ignored, ",")))
+	resetIgnoredFlag := flag.Bool("reset-ignored", false, fmt.Sprintf("replace the default paths skipped when extracting with those of -ignore"))
+	flag.Var(&migrateFlags, "migrate", fmt.Sprintf("configuration in the conf directory to migrate, such as context.xml, can be repeated (default %v)", strings.Join(defaultConfigs, ",")))
+	resetMigrateFlag := flag.Bool("reset-migrate", false, fmt.Sprintf("replace the default configurations to migrate with those of -migrate"))
+	noChownFlag := flag.Bool("no-chown", false, fmt.Sprintf("do not change the ownership of the new install, for when it is managed externally"))
+	noChmodFlag := flag.Bool("no-chmod", false, fmt.Sprintf("do not change the permissions of the configuration directory of the new install"))
+	noSymlinksFlag := flag.Bool("no-symlinks", false, fmt.Sprintf("do not create any symlinks, with -verbose those that are skipped are listed"))
+	noStreamFlag := flag.Bool("no-stream", false, fmt.Sprintf("save the archive to a local file before extracting it, instead of extracting it as it is downloaded"))
+	recoveryFlag := flag.Bool("recovery-mode", recovery, fmt.Sprintf("resume a failed run by reusing the local archive and only extracting missing files"))
+	quietFlag := flag.Bool("quiet", false, fmt.Sprintf("suppress terminal output"))
+	summaryFlag := flag.Bool("summary", summary, fmt.Sprintf("only print a one line JSON summary of the run, implies -quiet"))
+	jsonFlag := flag.Bool("json", jsonOutput, fmt.Sprintf("only print a JSON array of the download, checksum, extract, copy and symlink events of the run or the status as JSON, implies -quiet-errors"))
+	quietErrsFlag := flag.Bool("quiet-errors", quietErrs, fmt.Sprintf("suppress all terminal output including errors, implies -quiet"))
+	majorFlag := flag.String("major", "8", fmt.Sprintf("major version of Tomcat to download, %v", strings.Join(tomcatSeries, ", ")))
+	minorFlag := flag.String("minor", "", fmt.Sprintf("minor version of Tomcat to download, defaults to the newest series of -major"))
+	verFlag := flag.Int("ver", -1, fmt.Sprintf("point version of the Tomcat series to download, the latest is used when not run from a terminal"))
 	verboseFlag := flag.Bool("verbose", false, fmt.Sprintf("detail each file and directory that is handled"))
+	cleanAppsFlag := flag.Bool("require-clean-webapps", false, fmt.Sprintf("abort if the existing install has unexpected web applications"))
 	flag.Parse()
+	if *checkFlag {
+		errCode = ExitCheckFailed
+	}
+	distPath = *distPathFlag
+	distribution = strings.ToLower(*distFlag)
+	filterCmd = *filterCmdFlag
+	httpPort = *httpPortFlag
+	httpsPort = *httpsPortFlag
+	dryRun = *dryRunFlag
+	lineEnding = strings.ToLower(*lineEndingFlag)
+	linkName = *linkNameFlag
 	logErrs = *logErrsFlag
-	quiet = *quietFlag
+	minFreeMB = *minFreeFlag
+	pidFile = *pidFileFlag
+	placeholders = *holdersFlag
+	quiet := *quietFlag
+	recovery = *recoveryFlag
+	retries = *retriesFlag
+	tarEntryLimit = *entryLimitFlag
+	extractWorkers = *workersFlag
+	if extractWorkers < 1 {
+		extractWorkers = 1
+	}
+	tarSymlinkLimit = *linkLimitFlag
+	quietErrs = *quietErrsFlag
+	summary = *summaryFlag
+	jsonOutput = *jsonFlag
+	if jsonOutput == true {
+		quietErrs = true
+	}
+	if quietErrs == true || summary == true {
+		quiet = true
+	}
 	tomcatDir = *tomcatDirFlag
-	verbose = *verboseFlag
+	verbose := *verboseFlag
 	verF := *verFlag
+	if len(allowedFlag) > 0 {
+		allowedApps = allowedFlag
+	}
 
-	// check for existence of the Tomcat path
-	_, err := os.Stat(tomcatDir)
-	if os.IsNotExist(err) {
-		if quiet != true {
-			err = fmt.Errorf("The path to Tomcat %q cannot be found, please supply a different directory using --dir (directory)", tomcatDir)
+	confLinter = *linterFlag
+	skipChown = *skipChownFlag
+	sortProps = *sortPropsFlag
+	stripDebug = *stripDebugFlag
+	showDiff = *diffFlag
+	validateLinks = *validateLinksFlag
+	skipInvalid = *skipInvalidFlag
+	detectEnc = *detectEncFlag
+	transcode = *transcodeFlag
+	configs := append([]string{}, defaultConfigs...)
+	ownerSet := *userFlag != "" || *groupFlag != ""
+	if *configFlag != "" {
+		c, err := loadConfig(*configFlag)
+		checkErr(err)
+		applyConfig(c)
+		if len(c.Configs) > 0 {
+			configs = c.Configs
 		}
+		ownerSet = ownerSet || c.UserID != nil || c.GroupID != nil
+	}
+	// the version pinned by the install, flags take precedence
+	major, minor := *majorFlag, *minorFlag
+	pin := filepath.Join(tomcatDir, versionPin)
+	pinMajor, pinMinor, pinPatch, err := readVersionPin(pin)
+	if err != nil && !os.IsNotExist(err) {
 		checkErr(err)
 	}
-
-	// ask for Tomcat version if no valid flag is supplied
-	if verF == -1 {
-		fmt.Printf("Which edition of Tomcat %v.%v do you wish to download? For example enter 5 to download version %v.%v.5.\nv%v.%v.", ver1, ver2, ver1, ver2, ver1, ver2)
-		ver3, err = askVer()
-		// loop to keep asking for valid input
-		for err != nil {
-			ver3, err = askVer()
+	if err == nil {
+		if verbose == true {
+			fmt.Printf("\nVersion pin %v: %v.%v.%v", pin, pinMajor, pinMinor, pinPatch)
+		}
+		if isFlagSet("major") == false && isFlagSet("minor") == false {
+			major, minor = pinMajor, pinMinor
+		}
+		if isFlagSet("ver") == false && major == pinMajor && (minor == "" || minor == pinMinor) {
+			minor, verF = pinMinor, pinPatch
 		}
-	} else {
-		ver3 = verF
 	}
-
-	// build URL to download Tomcat
-	f := strings.Split(urlTemplate, "?")
-	dirname := fmt.Sprintf("%v%v.%v.%v", f[3], ver1, ver2, ver3)
-	filename := fmt.Sprintf("%v%v.%v.%v%v", f[3], ver1, ver2, ver3, f[4])
-	srcFile := fmt.Sprintf("%v%v%v%v.%v.%v%v%v", f[0], ver1, f[1], ver1, ver2, ver3, f[2], filename)
-	srcSha512 := fmt.Sprintf("%v.sha512", srcFile)
-	if quiet == false {
-		fmt.Printf("Will download Tomcat %v.%v.%v from URL: %v", ver1, ver2, ver3, srcFile)
+	if minor == "" {
+		minor = newestMinor(major)
 	}
-
-	// checksums
-	var lcs string                // local file checksum
-	rcs := getChecksum(srcSha512) // remote checksum hosted on tomcat.apache.org
-
-	// handle any local files with the same Tomcat archive filename
-	lfn, err := os.Open(filename)
-	defer lfn.Close()
-	if err == nil {
-		// if local file exists, check its SHA512 checksum against the one
-		// hosted on tomcat.apache.org
-		lfh := crypto.SHA512.New()
-		io.Copy(lfh, lfn)
-		lcs = strings.Split(fmt.Sprintf("%x", lfh.Sum(nil)), "*")[0]
+	if !contains(tomcatSeries, major+"."+minor) {
+		err := fmt.Errorf("Tomcat %v.%v is not a known series, use one of %v", major, minor, strings.Join(tomcatSeries, ", "))
+		checkErr(err)
 	}
-
-	// download remote Tomcat archive unless an identical local file already exists
-	if lcs != rcs {
-		download(filename, srcFile, rcs)
-	} else if quiet == false {
-		fmt.Printf("%v skipped file exists", prefix)
+	if isFlagSet("link-name") == false {
+		linkName = "tomcat" + major
 	}
-
-	// unpack tar.gz archive
-	tar := openGZip(filename, "")
-	// unpack tarball
-	_ = openTAR(tar, "")
-
-	// migrate existing configurations
-	cp(dirname, conf, configs...)
-
-	if runtime.GOOS != "windows" {
-		f := filepath.Join(dirname, conf)
-		// chmod g+wrx conf
-		mod, err := permbits.Stat(f)
+	// keep the resolved values for -export-env
+	flag.Set("major", major)
+	flag.Set("minor", minor)
+	flag.Set("link-name", linkName)
+	if *userFlag != "" {
+		id, err := lookupUserID(*userFlag)
 		checkErr(err)
-		if !mod.GroupWrite() {
-			mod.SetGroupWrite(true)
-			err := permbits.Chmod(f, mod)
-			checkErr(err)
-		}
-		if !mod.GroupRead() {
-			mod.SetGroupRead(true)
-			err := permbits.Chmod(f, mod)
-			checkErr(err)
-		}
-		if !mod.GroupExecute() {
-			mod.SetGroupExecute(true)
-			err := permbits.Chmod(f, mod)
+		userID = id
+	}
+	if *groupFlag != "" {
+		id, err := lookupGroupID(*groupFlag)
+		checkErr(err)
+		groupID = id
+	}
+	if *resetIgnoredFlag {
+		ignored = []string{}
+	}
+	for _, p := range ignoreFlags {
+		ignored = append(ignored, strings.Trim(filepath.ToSlash(p), "/"))
+	}
+	if *resetMigrateFlag {
+		configs = []string{}
+	}
+	for _, c := range migrateFlags {
+		if l := filepath.Clean(c); filepath.IsAbs(l) || l == ".." || strings.HasPrefix(l, ".."+string(filepath.Separator)) {
+			err := fmt.Errorf("The --migrate configuration %q must be a path within the conf directory", c)
 			checkErr(err)
 		}
-		// chown -R tomcat7:tomcat7
-		if quiet == false {
-			fmt.Printf("\nChange ownership of %v/ to user ID %v and group ID %v", dirname, userID, groupID)
+		if !contains(configs, c) {
+			configs = append(configs, c)
 		}
-		err = changeOwner(dirname, true, userID, groupID)
+	}
+	if size, err := humanize.ParseBytes(*maxConfFlag); err != nil {
+		err = fmt.Errorf("The --max-conf-size value %q is not a valid size: %v", *maxConfFlag, err)
 		checkErr(err)
-		if verbose == false && quiet == false {
-			fmt.Printf("%v done", prefix)
-		}
-		// create symbolic links
-		t := "/var/www/defacto2.2014/WEB-INF/web.xml"
-		sym := filepath.Join(dirname, "conf/lucee.xml")
-		createLink(t, sym)
-		t = "/var/www/defacto2.2014"
-		sym = filepath.Join(dirname, "webapps/ROOT/")
-		createLink(t, sym)
-		// create tomcat8 symbolic link
-		if _, err := os.Stat("tomcat8"); err == nil {
-			err = os.Rename("tomcat8", "tomcat8~")
-		}
-		createLink(dirname, "tomcat8")
+	} else {
+		maxConfSize = size
 	}
-	if quiet == false {
-		fmt.Printf("\nTomcat update complete\n")
+	if size, err := humanize.ParseBytes(*minTempFlag); err != nil {
+		err = fmt.Errorf("The --min-temp-space value %q is not a valid size: %v", *minTempFlag, err)
+		checkErr(err)
+	} else {
+		minTempSpace = size
 	}
-}
-
-func askVer() (int, error) {
-	reader := bufio.NewReader(os.Stdin)
-	i, _ := reader.ReadString('\n')
-	i = strings.Trim(i, "\n\r")
-	ver3, err := strconv.Atoi(i)
-	if err != nil {
-		fmt.Printf("\rThe version number needs to be a digit: ")
+	if size, err := humanize.ParseBytes(*maxSizeFlag); err != nil {
+		err = fmt.Errorf("The --max-archive-size value %q is not a valid size: %v", *maxSizeFlag, err)
+		checkErr(err)
+	} else {
+		maxArchiveSize = size
 	}
-	return ver3, err
-}
-
-func changeOwner(dir string, recursive bool, uID, gID int) error {
-	if recursive == false {
-		err := os.Chown(dir, uID, gID)
-		return err
+	if size, err := humanize.ParseBytes(*maxLogFlag); err != nil {
+		err = fmt.Errorf("The --log-max-size value %q is not a valid size: %v", *maxLogFlag, err)
+		checkErr(err)
+	} else {
+		maxLogSize = size
 	}
-	var c int
-	return filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+	if *logFileFlag != "" {
+		f, err := openLogFile(*logFileFlag, maxLogSize)
 		if err != nil {
-			return err
+			checkErr(fmt.Errorf("The --log-file could not be opened: %w", err))
 		}
-		c++
-		err = os.Chown(name, uID, gID)
-		if verbose == true {
-			fmt.Printf("\n%v. %v", c, name)
-			if err != nil {
-				fmt.Printf("%v failed", prefix)
-			}
+		defer f.Close()
+		log.SetOutput(io.MultiWriter(os.Stderr, f))
+		fileLog = log.New(f, "", log.LstdFlags)
+		logErrs = true
+	}
+	if *syslogFlag == true {
+		if err := openSyslog(); err != nil {
+			checkErr(fmt.Errorf("The --syslog logger could not be connected: %w", err))
 		}
-		return nil
-	})
-}
-
-func calcSHA512(filePath string) ([]byte, error) {
-	var result []byte
-	file, err := os.Open(filePath)
-	if err != nil {
-		return result, err
 	}
-	defer file.Close()
-
-	hash := sha512.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return result, err
+	confMode, err := strconv.ParseUint(*confPermsFlag, 8, 32)
+	if err != nil || confMode > 07777 {
+		err = fmt.Errorf("The --conf-permissions value %q is not a valid octal mode", *confPermsFlag)
+		checkErr(err)
 	}
-
-	return hash.Sum(result), nil
-}
-
-func cp(rootDir string, subDir string, files ...string) {
-	inFile, outFile := "", ""
-	inDir := filepath.Join(rootDir, subDir)
-	outDir := filepath.Join(tomcatDir, subDir)
-
-	for _, f := range files {
-		inFile = filepath.Join(outDir, f)
-		outFile = filepath.Join(inDir, f)
-		if quiet == false {
-			fmt.Printf("\n%v will be replaced", outFile)
+	builder, err := newURLBuilder(distribution, distPath, *distURLFlag, *baseURLFlag)
+	checkErr(err)
+	if *distKeysFlag != "" {
+		if distribution == "apache" {
+			err = fmt.Errorf("The --dist-keys-url can only be used with the vmware, redhat and custom distributions")
+			checkErr(err)
 		}
-
-		inCS, err := calcSHA512(inFile)
+		checkErr(requireHTTPS(*distKeysFlag))
+	}
+	switch lineEnding {
+	case "lf", "crlf", "preserve":
+	default:
+		err := fmt.Errorf("The --conf-line-ending value %q is not lf, crlf or preserve", lineEnding)
 		checkErr(err)
-
-		info, err := os.Stat(inFile)
+	}
+	if *shutdownTimeoutFlag < 0 || *shutdownGraceFlag < 0 {
+		err := fmt.Errorf("The --shutdown-timeout and --shutdown-grace-period durations cannot be negative")
 		checkErr(err)
-
-		if !info.Mode().IsRegular() {
-			err = fmt.Errorf("%v is not a valid file", inFile)
-		}
+	}
+	healthMethod := strings.ToUpper(*healthMethodFlag)
+	if healthMethod != http.MethodGet && healthMethod != http.MethodHead {
+		err := fmt.Errorf("The --health-endpoint-method value %q is not GET or HEAD", *healthMethodFlag)
 		checkErr(err)
-
-		in, err := os.Open(inFile)
+	}
+	if !regexp.MustCompile(`^[A-Za-z0-9._-]+$`).MatchString(linkName) || linkName == "." || linkName == ".." {
+		err := fmt.Errorf("The --link-name %q must only use letters, numbers, dots, hyphens and underscores", linkName)
 		checkErr(err)
-		defer in.Close()
+	}
+	for _, e := range extraFlags {
+		_, _, err := splitExtraFiles(e)
+		checkErr(err)
+	}
+	env := envOptions{heapMin: *envHeapMinFlag, heapMax: *envHeapMaxFlag, gc: *envGCFlag, opts: strings.Fields(*envOptsFlag)}
+	if _, err := env.flags(); err != nil {
+		checkErr(err)
+	}
+	if len(symlinkFlags) > 0 {
+		symlinks = []SymlinkPair{}
+	}
+	for _, v := range symlinkFlags {
+		s, err := splitSymlink(v)
+		checkErr(err)
+		symlinks = append(symlinks, s)
+	}
+	if transcode == true && detectEnc == false {
+		err := fmt.Errorf("The --transcode-to-utf8 flag requires --detect-encoding")
+		checkErr(err)
+	}
 
-		out, err := os.Create(outFile)
+	// print the effective configuration
+	if *exportEnvFlag {
+		printExportEnv()
+		return
+	}
+
+	// save the process ID so operators can signal the tool
+	if pidFile != "" {
+		err := writePID(pidFile)
 		checkErr(err)
-		defer out.Close()
+		defer removePID()
+	}
+	// SIGINT and SIGTERM cancel the downloads, extraction and ownership changes,
+	// a second signal exits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
-		_, err = io.Copy(out, in) // _ returns file size
+	// list the configuration backups
+	if flag.Arg(0) == "conf-backup" {
+		if flag.Arg(1) != "list" {
+			err := fmt.Errorf("Unknown conf-backup command %q, use: conf-backup list", flag.Arg(1))
+			checkErr(err)
+		}
+		err := printConfBackups(*confBackupDirFlag)
 		checkErr(err)
+		return
+	}
 
-		err = out.Sync()
+	// pin the TLS certificate of the download server
+	pinHost, err := downloadHost(builder)
+	checkErr(err)
+	if *printPinFlag {
+		hash, err := certHash(pinHost)
+		checkErr(err)
+		fmt.Println(hash)
+		return
+	}
+	if *distUserFlag == "" && (distribution == "vmware" || distribution == "redhat") {
+		err := fmt.Errorf("The %v distribution requires a subscription, use --dist-username and --dist-password", distribution)
 		checkErr(err)
+	}
+	// only the host of the distribution archives is sent the --dist-username credentials
+	distHost := ""
+	if u, err := url.Parse(builder.ArchiveURL(0, 0, 0)); err == nil {
+		distHost = u.Host
+	}
+	client, err := newHTTPClient(clientOptions{
+		proxy:           *proxyFlag,
+		pin:             *pinFlag,
+		pinHost:         pinHost,
+		iface:           *ifaceFlag,
+		user:            *distUserFlag,
+		password:        *distPassFlag,
+		authHost:        distHost,
+		logRequests:     *logReqFlag,
+		logHeaders:      *logHeadFlag,
+		userAgent:       *userAgentFlag,
+		ctx:             ctx,
+		connectTimeout:  *connectTimeoutFlag,
+		downloadTimeout: *downloadTimeoutFlag,
+	})
+	checkErr(err)
+	u := &Updater{
+		TomcatDir:    tomcatDir,
+		Quiet:        quiet,
+		Verbose:      verbose,
+		LogErrors:    logErrs,
+		Major:        major,
+		Minor:        minor,
+		PointVersion: verF,
+		HTTPClient:   client,
+		Stdout:       os.Stdout,
+		Stderr:       os.Stderr,
+		runOptions: runOptions{
+			accessLog:      *accessLogFlag,
+			accessPattern:  *accessPatternFlag,
+			allowExtra:     *allowExtraFlag,
+			allowHolders:   *allowHoldersFlag,
+			backupApps:     *backupAppsFlag,
+			backupDir:      *backupDirFlag,
+			backupLogs:     *backupLogsFlag,
+			builder:        builder,
+			checkPerms:     *checkPermsFlag,
+			checkPort:      *checkPortFlag,
+			cleanApps:      *cleanAppsFlag,
+			clearWork:      *clearWorkFlag,
+			confBackup:     *confBackupFlag,
+			confBackupDir:  *confBackupDirFlag,
+			configs:        configs,
+			confGID:        *confGIDFlag,
+			confMode:       confMode,
+			confUID:        *confUIDFlag,
+			distKeys:       *distKeysFlag,
+			env:            env,
+			downloadDir:    *downloadDirFlag,
+			dryRun:         dryRun,
+			extractDir:     *extractDirFlag,
+			extraFiles:     extraFlags,
+			extraOverwrite: *extraOverFlag,
+			fixAJP:         *fixAJPFlag,
+			fixShutdown:    *fixShutdownFlag,
+			fixWritable:    *fixWritableFlag,
+			force:          *forceFlag,
+			healthMethod:   healthMethod,
+			healthURL:      *healthURLFlag,
+			install:        *installFlag,
+			javaHome:       *javaHomeFlag,
+			jvmFlags:       jvmFlags,
+			keep:           *keepFlag,
+			keepArchive:    *keepArchiveFlag,
+			noChmod:        *noChmodFlag,
+			noChown:        *noChownFlag,
+			noStream:       *noStreamFlag,
+			noSymlinks:     *noSymlinksFlag,
+			permsReport:    *permsReportFlag,
+			portRequired:   *portRequiredFlag,
+			purgeWork:      *purgeWorkFlag,
+			postExtract:    *postExtractFlag,
+			proxyPort:      *proxyPortFlag,
+			proxyScheme:    *proxySchemeFlag,
+			proxySecure:    *proxySecureFlag,
+			reload:         *reloadFlag,
+			rotate:         *rotateFlag,
+			service:        *serviceFlag,
+			sha256File:     *sha256FileFlag,
+			skipChown:      skipChown,
+			shutdown:       ctx,
+			skipGPG:        *skipGPGFlag || (distribution != "apache" && *distKeysFlag == ""),
+			stopGrace:      *shutdownGraceFlag,
+			stopTimeout:    *shutdownTimeoutFlag,
+			stopTomcat:     *stopTomcatFlag,
+			strict:         *strictFlag,
+			tempDir:        *tempDirFlag,
+			verifyEnc:      *verifyEncFlag,
+			warnWritable:   *warnWritableFlag,
+			workDir:        *workDirFlag,
+		},
+	}
 
-		outCS, err := calcSHA512(outFile)
+	// remove files created by earlier runs
+	if *cleanupFlag {
+		err := u.cleanup([]string{".", *downloadDirFlag, *extractDirFlag, *backupDirFlag}, *assumeYesFlag)
 		checkErr(err)
+		if jsonOutput == true {
+			printEvents()
+		}
+		return
+	}
 
-		if fmt.Sprint(outCS) != fmt.Sprint(inCS) {
-			err = fmt.Errorf("%v did not copy correctly, aborting", inFile)
+	// report the state of the existing install, using the HTTP client for the available version
+	if flag.Arg(0) == "status" {
+		links := []string{linkName}
+		for _, s := range symlinks {
+			links = append(links, filepath.Join(tomcatDir, s.Link))
 		}
+		st, err := u.collectStatus(tomcatDir, links...)
 		checkErr(err)
+		printStatus(st)
+		return
+	}
 
-		if quiet == false {
-			fmt.Printf("%v done", prefix)
+	// report if a newer release is available
+	if *checkFlag {
+		code, err := u.checkUpdate(tomcatDir)
+		if err != nil {
+			exit("ERROR: ", err, code)
 		}
+		removePID()
+		os.Exit(code)
 	}
-}
 
-func createLink(target, symlink string) {
-	err := os.Symlink(target, symlink)
-	if quiet == false {
-		fmt.Printf("\nSymlink %v → %v", symlink, target)
+	// compare the install to its manifest
+	if *verifyFlag {
+		code, err := verifyInstall(ctx, tomcatDir)
 		if err != nil {
-			// display instead of log errors
-			es := strings.Fields(fmt.Sprint(err))
-			fmt.Printf("%v skipped %v", prefix, strings.Join(es[3:], " ")) // fetch and append error reason
+			exit("ERROR: ", err, code)
 		}
+		removePID()
+		os.Exit(code)
 	}
-}
 
-func download(filename string, url string, checksum string) {
-	// create a local file to save download to
-	lfn, err := os.Create(filename)
-	checkErr(err)
-	defer lfn.Close()
-	// download remote file metadata
-	head, err := http.Head(url)
-	checkErr(err)
-	checkHTTP(head)
-	if quiet == false {
-		fmt.Printf("\nDownloading file: %v, %v", filename, humanize.Bytes(uint64(head.ContentLength)))
-		lm := head.Header.Get("Last-Modified")
-		if len(lm) != 0 {
-			fmt.Printf(", %v\n", lm)
-		}
+	if ownerSet == false && *noChownFlag == false && runtime.GOOS != "windows" && u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "\nWarning: no --user or --group was given, the default user ID %v and group ID %v may not match this system", userID, groupID)
 	}
-	// download remote file data
-	resp, err := http.Get(url)
-	checkErr(err)
-	// save download to local file
-	defer resp.Body.Close()
-	_, err = io.Copy(lfn, resp.Body)
-	checkErr(err)
-	// validate the download after it is complete
-	calc, err := calcSHA512(filename)
-	checkErr(err)
-	ccs := fmt.Sprintf("%x", calc)
-	if ccs != checksum {
-		err := fmt.Errorf("The download failed as the checksum of %v does not match the expected checksum\nExpected: %q\n  Actual: %q", filename, checksum, ccs)
+
+	// restore the most recent installation backup
+	if *rollbackFlag {
+		if *backupDirFlag == "" {
+			err = fmt.Errorf("The --rollback flag requires --backup-dir (directory)")
+			checkErr(err)
+		}
+		name, err := latestBackup(*backupDirFlag)
 		checkErr(err)
-	} else {
-		if quiet == false {
-			fmt.Println("Download complete")
+		err = verifyBackup(name)
+		checkErr(err)
+		if entries, err := os.ReadDir(tomcatDir); err == nil && len(entries) > 0 && *assumeYesFlag == false {
+			if u.Quiet == true || !askYes(fmt.Sprintf("%v is not empty, overwrite it with %v?", tomcatDir, name)) {
+				err = fmt.Errorf("The rollback was cancelled as %v is not empty", tomcatDir)
+				checkErr(err)
+			}
+		}
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nRestoring %v to %v", name, tomcatDir)
 		}
+		if u.dryRunSkip("restore %v to %v", name, tomcatDir) == false {
+			err = restoreBackup(name, tomcatDir)
+			checkErr(err)
+			if runtime.GOOS != "windows" && u.noChown == false {
+				err = u.changeOwner(ctx, tomcatDir, true, userID, groupID)
+				checkErr(err)
+			}
+			if runtime.GOOS != "windows" && u.noChmod == false {
+				// chmod g+wrx conf
+				f := filepath.Join(tomcatDir, conf)
+				info, err := os.Stat(f)
+				checkErr(err)
+				err = os.Chmod(f, info.Mode()|0070)
+				checkErr(err)
+			}
+		}
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "%v done\n", prefix)
+		}
+		return
 	}
-}
 
-func openTAR(source, target string) string {
-	// open tarball
-	if quiet == false {
-		fmt.Printf("\nTarball content extraction")
+	// keep the --json and --summary output clean, the verbose output still goes to syslog
+	if u.Quiet == true {
+		u.Stdout = ioutil.Discard
+	}
+	if *syslogFlag == true && u.Verbose == true {
+		u.Stdout = io.MultiWriter(u.Stdout, &syslogDebug{})
+	}
+	stopUSR1 := u.notifyUSR1()
+	defer stopUSR1()
+	err = u.Run()
+	switch {
+	case errors.Is(err, ErrLocked):
+		exit("ERROR: ", err, ExitLocked)
+	case errors.Is(err, ErrContentRejected):
+		exit("ERROR: ", err, ExitContentRejected)
+	case errors.Is(err, ErrLintFailed):
+		exit("ERROR: ", err, ExitLintFailed)
 	}
-	reader, err := os.Open(source)
 	checkErr(err)
-	defer func() {
-		reader.Close()
-		if verbose == true {
-			fmt.Printf("\nCompleted tarball content extraction")
-		} else if quiet == false {
-			fmt.Printf("%v done", prefix)
-		}
-	}()
-	// loop and read through tarball
-	c, tar, dir := 0, tar.NewReader(reader), ""
-	var skip bool
-	var spl []string
-	var chk string
+}
+
+// Run downloads, extracts and configures the Tomcat release, then migrates
+// the configurations of the existing install to it. The run stops at the first error.
+func (u *Updater) Run() error {
+	// the long running steps stop when SIGINT or SIGTERM is received
+	ctx := u.shutdown
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// check for existence of the Tomcat path
+	_, err := os.Stat(u.TomcatDir)
+	if os.IsNotExist(err) && u.install {
+		if u.dryRunSkip("create the directory %v", u.TomcatDir) == false {
+			err = os.MkdirAll(u.TomcatDir, 0755)
+			if err != nil {
+				return err
+			}
+		}
+	} else if os.IsNotExist(err) {
+		if u.Quiet != true {
+			err = fmt.Errorf("The path to Tomcat %q cannot be found, please supply a different directory using --dir (directory)", u.TomcatDir)
+		}
+		return err
+	} else if u.install == false {
+		err = validateTomcatDir(u.TomcatDir, u.configs)
+		if err != nil {
+			return err
+		}
+	}
+
+	// prevent concurrent runs from changing the Tomcat directory
+	if u.dryRun == false {
+		lock, err = lockFile(filepath.Join(u.TomcatDir, lockName))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			unlockFile(lock)
+			lock = nil
+		}()
+	}
+
+	// check the directories used by the update are writable
+	if u.checkPerms {
+		dirs := []string{u.TomcatDir, ".", os.TempDir()}
+		if u.install == false {
+			dirs = append(dirs, filepath.Join(u.TomcatDir, conf))
+		}
+		if u.backupDir != "" {
+			dirs = append(dirs, u.backupDir)
+		}
+		for _, d := range dirs {
+			err = checkDirectoryWritable(d)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// check a non-standard work directory before anything is downloaded
+	if u.workDir != "" {
+		err = checkDirectoryWritable(u.workDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	// create the Tomcat temp directory and check it has enough space
+	if u.tempDir != "" && u.dryRunSkip("create the temp directory %v", u.tempDir) == false {
+		err = os.MkdirAll(u.tempDir, 0750)
+		if err != nil {
+			return err
+		}
+		if minTempSpace > 0 {
+			free, err := freeSpace(u.tempDir)
+			if err != nil {
+				return err
+			}
+			if free < minTempSpace {
+				return fmt.Errorf("The temp directory %v has %v free which is less than the %v required", u.tempDir, humanize.Bytes(free), humanize.Bytes(minTempSpace))
+			}
+		}
+	}
+
+	// check the symlink targets before anything is downloaded
+	if validateLinks == true && skipInvalid == false {
+		for _, s := range symlinks {
+			err = validateSymlinkTarget(s.Target)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// check the existing install only has approved web applications
+	if u.cleanApps && u.install == false {
+		extra, err := checkWebapps(filepath.Join(u.TomcatDir, webapps), allowedApps)
+		if err != nil {
+			return err
+		}
+		if len(extra) > 0 && u.allowExtra == false {
+			return fmt.Errorf("Unexpected web applications found in %v: %v\nAborting as only %v are allowed, use --allowed-webapp (name) or --allow-extra-webapps", filepath.Join(u.TomcatDir, webapps), strings.Join(extra, ", "), allowedApps.String())
+		} else if len(extra) > 0 && u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nUnexpected web applications found in %v: %v", filepath.Join(u.TomcatDir, webapps), strings.Join(extra, ", "))
+		}
+	}
+
+	// find the latest Tomcat version for unattended runs, or ask for it if no valid flag is supplied
+	if u.PointVersion == -1 && !term.IsTerminal(int(os.Stdin.Fd())) {
+		u.PointVersion, err = u.fetchLatestPointVersion(u.Major, u.Minor)
+		if err != nil {
+			return err
+		}
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "Latest Tomcat %v.%v release is v%v.%v.%v\n", u.Major, u.Minor, u.Major, u.Minor, u.PointVersion)
+		}
+	} else if u.PointVersion == -1 {
+		fmt.Fprintf(u.Stdout, "Which edition of Tomcat %v.%v do you wish to download? For example enter 5 to download version %v.%v.5.\nv%v.%v.", u.Major, u.Minor, u.Major, u.Minor, u.Major, u.Minor)
+		u.PointVersion, err = askVer()
+		// loop to keep asking for valid input
+		for err != nil {
+			u.PointVersion, err = askVer()
+		}
+	}
+	release = fmt.Sprintf("%v.%v.%v", u.Major, u.Minor, u.PointVersion)
+
+	// prevent an accidental update to the version that is already installed
+	if u.install == false {
+		if currentVer, err := installedVersion(u.TomcatDir); err == nil && currentVer == release {
+			if u.force == false {
+				return fmt.Errorf("Tomcat %v is already installed in %v, use --force to install it again", currentVer, u.TomcatDir)
+			}
+			if u.Quiet == false {
+				fmt.Fprintf(u.Stdout, "\nWarning: Tomcat %v is already installed in %v, it is installed again as --force is set\n", currentVer, u.TomcatDir)
+			}
+		}
+	}
+
+	// check the Java runtime supports the Tomcat release before downloading
+	if min, ok := minJava[u.Major+"."+u.Minor]; ok {
+		javaHome := u.javaHome
+		if javaHome == "" {
+			javaHome = os.Getenv("JAVA_HOME")
+		}
+		if _, err := exec.LookPath("java"); javaHome != "" || err == nil {
+			err = checkJavaVersion(javaHome, u.Major+"."+u.Minor, min)
+			if err != nil {
+				return err
+			}
+		} else if u.Verbose == true {
+			fmt.Fprintf(u.Stdout, "\nJava was not found, so its version is not checked")
+		}
+	}
+
+	// build URL to download Tomcat
+	dirname := archiveName + release
+	dirname = filepath.Join(u.extractDir, dirname)
+	major, _ := strconv.Atoi(u.Major)
+	minor, _ := strconv.Atoi(u.Minor)
+	srcFile := u.builder.ArchiveURL(major, minor, u.PointVersion)
+	err = requireHTTPS(srcFile)
+	if err != nil {
+		return err
+	}
+	filename := cacheName(srcFile, u.downloadDir)
+	if u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "Will download Tomcat %v from URL: %v", release, srcFile)
+	}
+
+	// run the pre-update hook
+	if preHook != "" && u.dryRunSkip("run the pre-update hook %v", preHook) == false {
+		err = u.runScript(preHook, dirname)
+		if err != nil {
+			return err
+		}
+	}
+
+	// checksums
+	phase = "download"
+	var rcs string // remote checksum
+	if u.sha256File != "" {
+		// checksum that was verified and transferred separately
+		rcs, err = readSHA256File(u.sha256File)
+		if err != nil {
+			return err
+		}
+	} else {
+		// probe the checksum host, which is never the --base-url mirror
+		sumURL := u.builder.ChecksumURL(major, minor, u.PointVersion)
+		srcSum := u.probeChecksum(strings.TrimSuffix(sumURL, ".sha512"), sumURL)
+		rcs, err = u.getChecksum(srcSum) // remote checksum hosted on tomcat.apache.org
+		if err != nil {
+			return err
+		}
+	}
+
+	// extract the archive as it is downloaded, unless an identical local file already
+	// exists, a recovery run reuses the local archive or the archive is to be cached
+	stream := u.noStream == false && recovery == false && u.downloadDir == "" && fileChecksum(filename, rcs) != rcs
+
+	// download remote Tomcat archive unless an identical local file already exists
+	if stream == false {
+		filename, err = u.cachedFetch(ctx, srcFile, rcs, u.downloadDir)
+		if err != nil {
+			return err
+		}
+		// remove the archive when the run ends, a cached archive is kept for other hosts
+		if u.keepArchive == false && u.downloadDir == "" && u.dryRun == false {
+			archives = append(archives, filename)
+			defer removeArchives()
+		}
+	}
+
+	// verify the PGP signature of the archive
+	if u.skipGPG {
+		reason := "-skip-gpg is set"
+		if distribution != "apache" && u.distKeys == "" {
+			reason = fmt.Sprintf("the %v distribution has no -dist-keys-url", distribution)
+		}
+		fmt.Fprintf(u.Stderr, "\nWARNING: the PGP signature of %v was not verified as %v\n", filename, reason)
+	} else if _, err := os.Stat(filename); stream == false && (err == nil || u.dryRun == false) {
+		signer, err := u.verifySignature(filename, srcFile+".asc", u.Major)
+		if err != nil {
+			return err
+		}
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nPGP signature of %v verified, signed by %v", filename, signer)
+		}
+	}
+
+	// unpack tar.gz archive
+	phase = "extract"
+	if u.extractDir != "" && u.dryRunSkip("create the extraction directory %v", u.extractDir) == false {
+		err = os.MkdirAll(u.extractDir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = os.Stat(dirname)
+	existed := err == nil
+	if stream && u.dryRunSkip("download and extract %v to %v", srcFile, dirname) == false {
+		err = u.streamExtract(ctx, srcFile, rcs, u.extractDir)
+		// files of an archive that fails its checksum or signature are untrusted, even within an existing install
+		if (err != nil && existed == false) || errors.Is(err, ErrChecksum) || errors.Is(err, ErrSignature) {
+			os.RemoveAll(dirname)
+		}
+		if err != nil {
+			return err
+		}
+	} else if stream == false && u.dryRunSkip("extract %v to %v", filename, dirname) == false {
+		_, err = u.Extract(ctx, filename, u.extractDir)
+		if err != nil && existed == false {
+			os.RemoveAll(dirname)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// run the post extraction script
+	if u.postExtract != "" && u.dryRunSkip("run the post extraction script %v", u.postExtract) == false {
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nRunning post extraction script: %v\n", u.postExtract)
+		}
+		err = u.runScript(u.postExtract, dirname)
+		if err != nil && existed == false {
+			os.RemoveAll(dirname)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// remove stale compiled JSPs
+	if u.clearWork {
+		workDir := filepath.Join(dirname, "work")
+		if u.workDir != "" {
+			workDir = u.workDir
+		}
+		if u.dryRun == true {
+			c, _ := countFiles(workDir)
+			u.dryRunSkip("remove %v files from the work directory %v", c, workDir)
+		} else {
+			err = checkDirectoryWritable(workDir)
+			if err != nil {
+				return err
+			}
+			if u.Quiet == false {
+				fmt.Fprintf(u.Stdout, "\nClearing work directory: %v", workDir)
+			}
+			c, err := clearDirectory(workDir)
+			if err != nil {
+				return err
+			}
+			if u.Quiet == false {
+				fmt.Fprintf(u.Stdout, "%v %v removed", prefix, c)
+			}
+		}
+	}
+	workDir := filepath.Join(dirname, "work")
+	if u.purgeWork && u.dryRunSkip("delete and recreate the work directory %v", workDir) == false {
+		freed, err := purgeDirectory(workDir)
+		if err != nil {
+			return err
+		}
+		if u.Verbose == true {
+			fmt.Fprintf(u.Stdout, "\nPurged the work directory %v, %v freed", workDir, humanize.Bytes(freed))
+		}
+	}
+
+	// deploy additional files such as JDBC drivers
+	for _, e := range u.extraFiles {
+		src, sub, _ := splitExtraFiles(e)
+		dst := filepath.Join(dirname, sub)
+		if u.dryRunSkip("copy the files of %v to %v", src, dst) {
+			continue
+		}
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nCopying extra files from %v to %v", src, dst)
+		}
+		c, err := copyExtraFiles(src, dst, u.extraOverwrite)
+		if err != nil {
+			return err
+		}
+		if u.Verbose == true {
+			fmt.Fprintf(u.Stdout, "%v %v copied", prefix, c)
+		} else if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "%v done", prefix)
+		}
+	}
+
+	// scan the extracted files for world-writable permissions
+	if (u.warnWritable || u.fixWritable) && u.dryRun == false {
+		paths, err := checkWorldWritable(dirname)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			if u.Quiet == false {
+				fmt.Fprintf(u.Stdout, "\nWorld-writable: %v", p)
+			}
+			if u.fixWritable {
+				err = fixWorldWritable(p)
+				if err != nil {
+					return err
+				}
+				if u.Quiet == false {
+					fmt.Fprintf(u.Stdout, "%v fixed", prefix)
+				}
+			}
+		}
+	}
+
+	// check the encoding of existing XML configurations
+	phase = "migrate"
+	if (u.verifyEnc || detectEnc) && u.install == false {
+		for _, c := range u.configs {
+			path := filepath.Join(u.TomcatDir, conf, c)
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				continue
+			}
+			if detectEnc == true {
+				enc, err := detectFileEncoding(path)
+				if err != nil {
+					return err
+				}
+				if u.Verbose == true {
+					fmt.Fprintf(u.Stdout, "\n%v encoding: %v", path, enc)
+				}
+				if enc != "UTF-8" {
+					continue
+				}
+			}
+			if u.verifyEnc == false || strings.ToLower(filepath.Ext(c)) != ".xml" {
+				continue
+			}
+			err = verifyXMLEncoding(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// report configuration permissions
+	expected := fileMode(uint32(u.confMode))
+	if u.permsReport && u.Quiet == false && u.install == false {
+		err = u.printPermReport(filepath.Join(u.TomcatDir, conf), expected)
+		if err != nil {
+			return err
+		}
+	}
+
+	// backup the existing install
+	if u.backupDir != "" && u.install == false && u.dryRunSkip("backup %v to %v", u.TomcatDir, u.backupDir) == false {
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nBackup of %v", u.TomcatDir)
+		}
+		name, err := backupInstallation(u.TomcatDir, u.backupDir, u.backupLogs, u.backupApps)
+		if err != nil {
+			return err
+		}
+		sum, err := writeChecksumFile(name)
+		if err != nil {
+			return err
+		}
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "%v saved to %v\nSHA-256: %v", prefix, name, sum)
+		}
+	}
+
+	// backup existing configurations
+	if u.confBackup && u.install == false && u.dryRunSkip("backup the configurations to %v", u.confBackupDir) == false {
+		name, err := createConfBackup(filepath.Join(u.TomcatDir, conf), u.confBackupDir)
+		if err != nil {
+			return err
+		}
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nConfigurations saved to %v", name)
+		}
+	}
+
+	// migrate existing configurations, a new install keeps the defaults from the archive
+	if u.install == false {
+		err = u.cp(dirname, conf, u.configs...)
+		if err != nil {
+			return err
+		}
+	}
+	if u.permsReport && u.Quiet == false && u.dryRun == false {
+		err = u.printPermReport(filepath.Join(dirname, conf), expected)
+		if err != nil {
+			return err
+		}
+	}
+
+	// generate the startup script and keep its site-local overrides
+	if u.env.empty() == false && u.dryRunSkip("generate %v", setenv) == false {
+		err = writeSetenv(filepath.Join(dirname, setenv), u.env)
+		if err != nil {
+			return err
+		}
+		if u.install == false {
+			kept, err := keepSetenvLocal(u.TomcatDir, dirname)
+			if err != nil {
+				return err
+			}
+			if kept && u.Verbose == true {
+				fmt.Fprintf(u.Stdout, "\nKept the site-local %v", setenvLocal)
+			}
+		}
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nGenerated %v", filepath.Join(dirname, setenv))
+		}
+	}
+
+	// append JVM flags to the startup script
+	if len(u.jvmFlags) > 0 && u.dryRunSkip("append %v to JAVA_OPTS in %v", strings.Join(u.jvmFlags, " "), setenv) == false {
+		err = setJavaOpts(filepath.Join(dirname, setenv), u.jvmFlags)
+		if err != nil {
+			return err
+		}
+	}
+
+	// use the Tomcat temp directory for java.io.tmpdir
+	if u.tempDir != "" && u.dryRunSkip("set java.io.tmpdir to %v in %v", u.tempDir, setenv) == false {
+		dir, err := filepath.Abs(u.tempDir)
+		if err != nil {
+			return err
+		}
+		err = appendOpts(filepath.Join(dirname, setenv), "CATALINA_OPTS", []string{"-Djava.io.tmpdir=" + dir})
+		if err != nil {
+			return err
+		}
+		if runtime.GOOS != "windows" && u.noChown == false {
+			err = u.changeOwner(ctx, dir, false, userID, groupID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// overlay connector ports onto the migrated server.xml
+	serverXML := filepath.Join(dirname, conf, "server.xml")
+	if httpPort != 0 && u.dryRunSkip("set the HTTP connector port to %v", httpPort) == false {
+		err = setConnectorPort(serverXML, httpProtocol, httpPort)
+		if err != nil {
+			return err
+		}
+	}
+	if httpsPort != 0 && u.dryRunSkip("set the HTTPS connector port to %v", httpsPort) == false {
+		err = setConnectorPort(serverXML, httpsProtocol, httpsPort)
+		if err != nil {
+			return err
+		}
+	}
+	if scheme := strings.ToLower(u.proxyScheme); scheme != "" && u.dryRunSkip("set the proxy scheme to %v", scheme) == false {
+		port, secure := u.proxyPort, u.proxySecure
+		if scheme == "https" && port == 0 {
+			port = 443
+		}
+		if scheme == "https" && isFlagSet("proxy-secure") == false {
+			secure = true
+		}
+		err = configureProxyScheme(serverXML, scheme, port, secure)
+		if err != nil {
+			return err
+		}
+	}
+
+	if u.accessLog && u.dryRunSkip("enable the access log in %v", serverXML) == false {
+		err = configureAccessLog(serverXML, filepath.Join(u.TomcatDir, "logs"), "localhost_access_log", ".txt", u.accessPattern)
+		if err != nil {
+			return err
+		}
+	}
+
+	// check for AJP connectors exposed to CVE-2020-1938 (Ghostcat)
+	if u.fixAJP && u.dryRunSkip("bind the AJP connectors of %v to 127.0.0.1", serverXML) == false {
+		c, err := fixAJP(serverXML)
+		if err != nil {
+			return err
+		}
+		if c > 0 && u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\n%v AJP connectors bound to 127.0.0.1 with a generated secret", c)
+		}
+	}
+	if c, err := findExposedAJP(serverXML); err == nil && c > 0 && quietErrs == false {
+		fmt.Fprintf(u.Stderr, "\nWARNING: %v has %v AJP connectors that are not bound to 127.0.0.1, which exposes Tomcat to CVE-2020-1938 (Ghostcat).\n"+
+			"Add address=\"127.0.0.1\" secret=\"...\" to each <Connector protocol=\"%v\"> or use -fix-ajp\n", serverXML, c, ajpProtocol)
+	}
+
+	// check for the default shutdown port and command that any local process can use
+	if u.fixShutdown && u.dryRunSkip("replace the default shutdown port and command of %v", serverXML) == false {
+		port, err := fixShutdownPort(serverXML)
+		if err != nil {
+			return err
+		}
+		if port > 0 && u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nShutdown port changed to %v with a random shutdown command", port)
+		}
+	}
+	if found, err := findDefaultShutdown(serverXML); err == nil && found && u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "\nWarning: %v uses the default shutdown port 8005 and command SHUTDOWN, use -fix-shutdown-port to replace them", serverXML)
+	}
+
+	// stop the running Tomcat before the new install is linked
+	running := filepath.Join(u.TomcatDir, conf, "server.xml")
+	stopped := false
+	if u.stopTomcat && u.dryRunSkip("stop the Tomcat of %v", u.TomcatDir) == false {
+		err = u.shutdownTomcat(ctx, running)
+		if err != nil {
+			return err
+		}
+		stopped = true
+	}
+
+	// check the HTTP port is free for when Tomcat is restarted
+	if isFlagSet("check-port") || u.portRequired {
+		port := u.checkPort
+		if isFlagSet("check-port") == false {
+			if p, err := connectorPort(serverXML, httpProtocol); err == nil && p > 0 {
+				port = p
+			}
+		}
+		// the port of the existing install is held by the running Tomcat until it is restarted
+		current, err := connectorPort(running, httpProtocol)
+		if err == nil && current == port && stopped == false {
+			if u.Verbose == true {
+				fmt.Fprintf(u.Stdout, "\nPort %v is not checked as it is used by the existing install", port)
+			}
+		} else if err := checkPortFree(port); err != nil {
+			if u.portRequired {
+				return err
+			}
+			if u.Quiet == false {
+				fmt.Fprintf(u.Stdout, "\nWarning: %v", err)
+			}
+		}
+	}
+
+	phase = "permissions"
+	if runtime.GOOS != "windows" {
+		f := filepath.Join(dirname, conf)
+		// chmod g+wrx conf
+		if u.noChmod && u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nPermissions of %v/ are unchanged as --no-chmod is set", f)
+		} else if u.noChmod == false && u.dryRunSkip("chmod g+rwx %v", f) == false {
+			mod, err := permbits.Stat(f)
+			if err != nil {
+				return err
+			}
+			if !mod.GroupWrite() {
+				mod.SetGroupWrite(true)
+				err := permbits.Chmod(f, mod)
+				if err != nil {
+					return err
+				}
+			}
+			if !mod.GroupRead() {
+				mod.SetGroupRead(true)
+				err := permbits.Chmod(f, mod)
+				if err != nil {
+					return err
+				}
+			}
+			if !mod.GroupExecute() {
+				mod.SetGroupExecute(true)
+				err := permbits.Chmod(f, mod)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		// chown -R tomcat7:tomcat7
+		if u.noChown && u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nOwnership of %v/ is unchanged as --no-chown is set", dirname)
+		}
+		if u.noChown == false {
+			if u.Quiet == false {
+				fmt.Fprintf(u.Stdout, "\nChange ownership of %v/ to user ID %v and group ID %v", dirname, userID, groupID)
+			}
+			err = u.changeOwner(ctx, dirname, true, userID, groupID)
+			if err != nil {
+				return err
+			}
+			if u.Verbose == false && u.Quiet == false {
+				fmt.Fprintf(u.Stdout, "%v done", prefix)
+			}
+		}
+		// chown tomcat configurations with a separate ownership
+		if u.noChown == false && (u.confUID >= 0 || u.confGID >= 0) {
+			uid, gid := userID, groupID
+			if u.confUID >= 0 {
+				uid = u.confUID
+			}
+			if u.confGID >= 0 {
+				gid = u.confGID
+			}
+			f := filepath.Join(dirname, conf)
+			if u.Quiet == false {
+				fmt.Fprintf(u.Stdout, "\nChange ownership of %v/ to user ID %v and group ID %v", f, uid, gid)
+			}
+			err = u.changeOwner(ctx, f, false, uid, gid)
+			if err != nil {
+				return err
+			}
+			if u.Verbose == false && u.Quiet == false {
+				fmt.Fprintf(u.Stdout, "%v done", prefix)
+			}
+		}
+		// create symbolic links
+		phase = "symlinks"
+		if u.noSymlinks {
+			if u.Verbose == true {
+				for _, s := range symlinks {
+					fmt.Fprintf(u.Stdout, "\nSymlink %v → %v%v skipped as --no-symlinks is set", filepath.Join(dirname, s.Link), s.Target, prefix)
+				}
+				fmt.Fprintf(u.Stdout, "\nSymlink %v → %v%v skipped as --no-symlinks is set", linkName, dirname, prefix)
+			}
+		} else {
+			for _, s := range symlinks {
+				err = u.createLink(s.Target, filepath.Join(dirname, s.Link))
+				if err != nil {
+					return err
+				}
+			}
+			// create the version-neutral symbolic link
+			if _, err := os.Stat(linkName); err == nil && u.dryRunSkip("rename %v to %v~", linkName, linkName) == false {
+				err = os.Rename(linkName, linkName+"~")
+			}
+			err = u.createLink(dirname, linkName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// save the manifest of the new install
+	phase = "manifest"
+	manifest := filepath.Join(dirname, manifestName)
+	if u.dryRunSkip("save the manifest of %v to %v", dirname, manifest) == false {
+		m, err := buildManifest(ctx, dirname, release)
+		if err != nil {
+			return err
+		}
+		err = writeManifest(manifest, m)
+		if err != nil {
+			return err
+		}
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nManifest of %v files saved to %v", len(m.Files), manifest)
+		}
+	}
+	// rotate configuration backups
+	if u.confBackup && u.rotate && u.dryRunSkip("remove the older configuration backups in %v", u.confBackupDir) == false {
+		removed, err := pruneConfBackups(u.confBackupDir, u.keep)
+		if err != nil {
+			return err
+		}
+		if u.Verbose == true {
+			for _, r := range removed {
+				fmt.Fprintf(u.Stdout, "\nRemoved old configuration backup %v", r)
+			}
+		}
+	}
+	// restart the Tomcat service
+	if u.service != "" {
+		action := "restart"
+		if u.reload {
+			action = "reload"
+		}
+		if u.dryRunSkip("systemctl %v %v", action, u.service) == false {
+			err = u.restartService(u.service, action)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// wait for the updated Tomcat to respond
+	if u.healthURL != "" && u.dryRunSkip("poll %v until Tomcat responds", u.healthURL) == false {
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nWaiting for %v to respond", u.healthURL)
+		}
+		err = u.waitForHealthy(ctx, u.healthURL)
+		if err != nil {
+			return err
+		}
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "%v done", prefix)
+		}
+	}
+
+	// run the post-update hook
+	if postHook != "" && u.dryRunSkip("run the post-update hook %v", postHook) == false {
+		err = u.runScript(postHook, dirname)
+		if err != nil {
+			return err
+		}
+	}
+	if u.Quiet == false {
+		if u.dryRun == true {
+			fmt.Fprintf(u.Stdout, "\nDry run complete, nothing was changed\n")
+		} else if u.install {
+			fmt.Fprintf(u.Stdout, "\nTomcat install complete\n")
+		} else {
+			fmt.Fprintf(u.Stdout, "\nTomcat update complete\n")
+		}
+		if ctx.Err() != nil {
+			fmt.Fprintf(u.Stdout, "Shutdown was requested, exiting\n")
+		}
+	}
+	// configurations rejected by the linter fail the run once the update is complete,
+	// the error is reported in place of the summary and the events
+	if lintFailures > 0 {
+		phase = "migrate"
+		return fmt.Errorf("%w, %v configurations were not migrated as %v rejected them", ErrLintFailed, lintFailures, confLinter)
+	}
+	if summary == true {
+		printSummary(nil)
+	}
+	if jsonOutput == true {
+		printEvents()
+	}
+	return nil
+}
+
+// versionPin is the file of a Tomcat install that pins the version used by updates.
+const versionPin = ".tomcat-version"
+
+// readVersionPin returns the major, minor and point version of a version pin file
+// that contains a MAJOR.MINOR.PATCH version such as 8.5.97.
+func readVersionPin(name string) (string, string, int, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", "", -1, err
+	}
+	v := strings.TrimPrefix(strings.TrimSpace(string(data)), "v")
+	spl := strings.Split(v, ".")
+	if len(spl) != 3 {
+		return "", "", -1, fmt.Errorf("The version pin %v must contain a MAJOR.MINOR.PATCH version, such as 8.5.97, not %q", name, v)
+	}
+	patch, err := strconv.Atoi(spl[2])
+	if err != nil || patch < 0 {
+		return "", "", -1, fmt.Errorf("The version pin %v has an invalid point version %q", name, spl[2])
+	}
+	return spl[0], spl[1], patch, nil
+}
+
+func askVer() (int, error) {
+	reader := bufio.NewReader(os.Stdin)
+	i, _ := reader.ReadString('\n')
+	i = strings.Trim(i, "\n\r")
+	ver3, err := strconv.Atoi(i)
+	if err != nil {
+		fmt.Printf("\rThe version number needs to be a digit: ")
+	}
+	return ver3, err
+}
+
+// fetchLatestPointVersion returns the highest point version of the major.minor
+// Tomcat series linked from the Apache Tomcat download page.
+func (u *Updater) fetchLatestPointVersion(major, minor string) (int, error) {
+	urlPage := downloadPage(major)
+	resp, err := u.HTTPClient.Get(urlPage)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("The download page %v returned %v", urlPage, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	href := regexp.MustCompile(`(?i)<a\s[^>]*href\s*=\s*["']([^"']+)["']`)
+	ver := regexp.MustCompile(`\b` + regexp.QuoteMeta(major+"."+minor+".") + `(\d+)\b`)
+	latest := -1
+	for _, h := range href.FindAllSubmatch(data, -1) {
+		for _, m := range ver.FindAllSubmatch(h[1], -1) {
+			if n, err := strconv.Atoi(string(m[1])); err == nil && n > latest {
+				latest = n
+			}
+		}
+	}
+	if latest < 0 {
+		return 0, fmt.Errorf("No Tomcat %v.%v release was found on %v, use --ver to choose the version", major, minor, urlPage)
+	}
+	return latest, nil
+}
+
+// runScript runs the shell command with the new and existing Tomcat directories
+// provided as the TOMCAT_NEW_DIR and TOMCAT_INSTALL_DIR environment variables.
+func (u *Updater) runScript(command, newDir string) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), "TOMCAT_NEW_DIR="+newDir, "TOMCAT_INSTALL_DIR="+u.TomcatDir)
+	if u.Quiet == false {
+		cmd.Stdout = u.Stdout
+	}
+	cmd.Stderr = u.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("The script %q failed: %v", command, err)
+	}
+	return nil
+}
+
+// lintConf runs the linter command with the configuration file as its argument.
+// The output of the linter is returned with the error when it exits non-zero.
+func lintConf(linter, path string) error {
+	out, err := exec.Command(linter, path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("The linter %v rejected %v: %v\n%s", linter, path, err, out)
+	}
+	return nil
+}
+
+// restartService runs systemctl with the action, restart or reload, for the service.
+// A missing systemctl is reported as a warning.
+func (u *Updater) restartService(service, action string) error {
+	path, err := exec.LookPath("systemctl")
+	if err != nil {
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nWarning: systemctl was not found, %v the %v service manually", action, service)
+		}
+		return nil
+	}
+	if u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "\nRunning systemctl %v %v", action, service)
+	}
+	cmd := exec.Command(path, action, service)
+	cmd.Stdout, cmd.Stderr = u.Stdout, u.Stderr
+	if u.Quiet == true {
+		cmd.Stdout = ioutil.Discard
+	}
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("systemctl %v %v failed: %v", action, service, err)
+	}
+	if u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "%v done", prefix)
+	}
+	return nil
+}
+
+// writePID saves the process ID of the tool to the named file.
+func writePID(name string) error {
+	return ioutil.WriteFile(name, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+}
+
+// removePID deletes the process ID file, if one was written.
+func removePID() {
+	if pidFile != "" {
+		os.Remove(pidFile)
+	}
+}
+
+// lockName is the lock file in the Tomcat directory held for the duration of a run.
+const lockName = ".tomcatupdate.lock"
+
+// lock is the lock file of the run, released when the run ends.
+var lock *os.File
+
+// archives are the downloaded and intermediate archives removed when the run ends.
+var archives []string
+
+// removeArchives deletes the archives, unless --keep-archive is set.
+func removeArchives() {
+	for _, name := range archives {
+		os.Remove(name)
+	}
+	archives = nil
+}
+
+// checkDirectoryWritable returns an error if a file cannot be created in dir.
+func checkDirectoryWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".tomcatupdate-probe-*")
+	if err != nil {
+		return fmt.Errorf("The directory %v is not writable: %v", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// tomcatSubDirs are the sub-directories of every Tomcat install.
+var tomcatSubDirs = []string{"bin", "conf", "lib", "logs", "webapps", "work"}
+
+// validateTomcatDir returns an error if dir does not have the sub-directories of a Tomcat
+// install, or if its conf sub-directory has none of the configs to migrate.
+func validateTomcatDir(dir string, configs []string) error {
+	var found, missing []string
+	for _, d := range tomcatSubDirs {
+		if info, err := os.Stat(filepath.Join(dir, d)); err == nil && info.IsDir() {
+			found = append(found, d+"/")
+			continue
+		}
+		missing = append(missing, d+"/")
+	}
+	confs := 0
+	for _, c := range configs {
+		if _, err := os.Stat(filepath.Join(dir, conf, c)); err == nil {
+			found = append(found, filepath.Join(conf, c))
+			confs++
+		}
+	}
+	if confs == 0 {
+		missing = append(missing, "one of "+strings.Join(configs, ", ")+" in "+conf+"/")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%v does not look like a Tomcat install, please check the --dir (directory)\nExpected: %v\n   Found: %v\n Missing: %v", dir, strings.Join(tomcatSubDirs, "/, ")+"/ and a configuration", strings.Join(found, ", "), strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// dryRunSkip prints the action and returns true when --dry-run is set,
+// so the caller can skip the change to the filesystem.
+func (u *Updater) dryRunSkip(format string, a ...interface{}) bool {
+	if u.dryRun == false {
+		return false
+	}
+	if u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "\nDry run, skipped: "+format, a...)
+	}
+	return true
+}
+
+// countFiles returns the number of files in dir and its sub-directories.
+func countFiles(dir string) (int, error) {
+	c := 0
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			c++
+		}
+		return err
+	})
+	return c, err
+}
+
+// clearDirectory removes the contents of dir but not the directory itself.
+// The number of removed files is returned.
+func clearDirectory(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	c := 0
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		n, err := countFiles(path)
+		if err != nil {
+			return c, err
+		}
+		c += n
+		if err = os.RemoveAll(path); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// purgeDirectory deletes dir and recreates it with the same permissions and ownership,
+// the number of bytes freed is returned. A missing dir is ignored.
+func purgeDirectory(dir string) (uint64, error) {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var freed uint64
+	err = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type().IsRegular() == false {
+			return err
+		}
+		fi, err := d.Info()
+		if err == nil {
+			freed += uint64(fi.Size())
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err = os.RemoveAll(dir); err != nil {
+		return 0, err
+	}
+	if err = os.MkdirAll(dir, info.Mode().Perm()); err != nil {
+		return freed, err
+	}
+	// MkdirAll permissions are reduced by the umask
+	if err = os.Chmod(dir, info.Mode().Perm()); err != nil {
+		return freed, err
+	}
+	if uid, gid := fileOwner(info); uid >= 0 {
+		return freed, os.Chown(dir, uid, gid)
+	}
+	return freed, nil
+}
+
+// validateSymlinkTarget returns an error if the target does not exist or cannot be read.
+func validateSymlinkTarget(target string) error {
+	f, err := os.Open(target)
+	if err != nil {
+		return fmt.Errorf("The symlink target %v is not valid: %v", target, err)
+	}
+	return f.Close()
+}
+
+// checkWebapps returns the entries in webappsDir that are not listed in allowedApps.
+func checkWebapps(webappsDir string, allowedApps []string) ([]string, error) {
+	files, err := ioutil.ReadDir(webappsDir)
+	if err != nil {
+		return nil, err
+	}
+	var extra []string
+	for _, f := range files {
+		if !contains(allowedApps, f.Name()) {
+			extra = append(extra, f.Name())
+		}
+	}
+	return extra, nil
+}
+
+// clientOptions are the settings of the HTTP client used for all downloads.
+type clientOptions struct {
+	proxy           string // proxy URL, otherwise HTTP_PROXY, HTTPS_PROXY and NO_PROXY are used
+	pin             string // SHA-256 hash of the download server's public key
+	pinHost         string // host of the download server, other hosts are not pinned
+	iface           string // network interface to bind the downloads to
+	user, password  string // basic authentication credentials
+	authHost        string // host of the distribution that is sent the credentials
+	logRequests     bool
+	logHeaders      bool
+	userAgent       string          // User-Agent header of all requests, otherwise the Go default is used
+	ctx             context.Context // cancels the requests that are not given their own context
+	connectTimeout  time.Duration   // time limit to connect to the server, 0 is unlimited
+	downloadTimeout time.Duration   // time limit of each request including the response body, 0 is unlimited
+}
+
+// newHTTPClient returns the HTTP client used for all downloads.
+func newHTTPClient(o clientOptions) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if o.proxy != "" {
+		u, err := url.Parse(o.proxy)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			return nil, fmt.Errorf("The --proxy %q must be a http, https or socks5 URL such as http://proxy.example.com:8080", o.proxy)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	transport.TLSClientConfig = &tls.Config{}
+	if o.pin != "" {
+		transport.TLSClientConfig = pinnedTLSConfig(o.pin, o.pinHost)
+	}
+	transport.TLSClientConfig.MinVersion = tls.VersionTLS12
+	if o.connectTimeout < 0 || o.downloadTimeout < 0 {
+		return nil, fmt.Errorf("The --connect-timeout and --download-timeout durations cannot be negative")
+	}
+	dialer := &net.Dialer{Timeout: o.connectTimeout, KeepAlive: 30 * time.Second}
+	// bind downloads to a network interface
+	if o.iface != "" {
+		addr, err := bindToInterface(o.iface)
+		if err != nil {
+			return nil, err
+		}
+		dialer.LocalAddr = addr
+	}
+	transport.DialContext = dialer.DialContext
+	var rt http.RoundTripper = transport
+	if o.user != "" {
+		rt = basicAuthTransport{user: o.user, password: o.password, host: o.authHost, base: rt}
+	}
+	if o.ctx != nil {
+		base := rt
+		rt = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Context() == context.Background() {
+				req = req.WithContext(o.ctx)
+			}
+			return base.RoundTrip(req)
+		})
+	}
+	if o.userAgent != "" {
+		base := rt
+		rt = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			r := req.Clone(req.Context())
+			r.Header.Set("User-Agent", o.userAgent)
+			return base.RoundTrip(r)
+		})
+	}
+	if o.logRequests || o.logHeaders {
+		rt = loggingTransport{base: rt, requests: o.logRequests, headers: o.logHeaders}
+	}
+	// refuse redirects from https to plain http
+	redirect := func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		return requireHTTPS(req.URL.String())
+	}
+	return &http.Client{Transport: rt, CheckRedirect: redirect, Timeout: o.downloadTimeout}, nil
+}
+
+// bindToInterface returns the local address of the named network interface.
+// The first non-loopback IPv4 address is preferred, otherwise the first IPv6 address is used.
+func bindToInterface(interfaceName string) (*net.TCPAddr, error) {
+	iface, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var ip6 net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() {
+			continue
+		}
+		if ip4 := ipnet.IP.To4(); ip4 != nil {
+			return &net.TCPAddr{IP: ip4}, nil
+		}
+		if ip6 == nil {
+			ip6 = ipnet.IP
+		}
+	}
+	if ip6 != nil {
+		addr := &net.TCPAddr{IP: ip6}
+		if ip6.IsLinkLocalUnicast() {
+			addr.Zone = interfaceName
+		}
+		return addr, nil
+	}
+	return nil, fmt.Errorf("The network interface %v has no usable IP address", interfaceName)
+}
+
+// certHash returns the SHA-256 hex hash of the public key of the host's TLS certificate.
+func certHash(host string) (string, error) {
+	conn, err := tls.Dial("tcp", host+":443", nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", fmt.Errorf("%v did not supply a certificate", host)
+	}
+	sum := sha256.Sum256(certs[0].RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// pinnedTLSConfig requires the certificate public key of the host to match the SHA-256
+// pubKeyHash. Other hosts, such as the checksum and KEYS servers, are not pinned.
+// The standard certificate verification is still applied.
+func pinnedTLSConfig(pubKeyHash, host string) *tls.Config {
+	pin := strings.ToLower(strings.TrimSpace(pubKeyHash))
+	return &tls.Config{
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if strings.EqualFold(cs.ServerName, host) == false {
+				return nil
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("The server did not supply a certificate")
+			}
+			cert := cs.PeerCertificates[0]
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if hash := hex.EncodeToString(sum[:]); hash != pin {
+				return fmt.Errorf("The certificate of %v does not match the pinned hash\nExpected: %q\n  Actual: %q", cert.Subject.CommonName, pin, hash)
+			}
+			return nil
+		},
+	}
+}
+
+// askYes prompts the question and returns true if the reply is yes.
+func askYes(question string) bool {
+	fmt.Printf("%v [y/N] ", question)
+	reader := bufio.NewReader(os.Stdin)
+	r, _ := reader.ReadString('\n')
+	r = strings.ToLower(strings.TrimSpace(r))
+	return r == "y" || r == "yes"
+}
+
+// workFiles are the files created by the tool in the work, download, extract and backup directories.
+var workFiles = []string{"apache-tomcat-*.tar.gz", "apache-tomcat-*.tar.gz.sha*", "apache-tomcat-*.tar", ".tomcatupdate-*.tar", "tomcat[0-9]*-*.tar.gz", "tomcat[0-9]*-*.tar.gz.sha256", "tomcatupdate-dryrun-*.txt"}
+
+// cleanup removes the archives, backups and reports created by the tool in the dirs,
+// empty and repeated dirs are ignored. Unless assumeYes is set the removal must be confirmed.
+func (u *Updater) cleanup(dirs []string, assumeYes bool) error {
+	var files, scanned []string
+	for _, dir := range dirs {
+		if dir == "" || contains(scanned, filepath.Clean(dir)) {
+			continue
+		}
+		scanned = append(scanned, filepath.Clean(dir))
+		for _, p := range workFiles {
+			m, err := filepath.Glob(filepath.Join(dir, p))
+			if err != nil {
+				return err
+			}
+			files = append(files, m...)
+		}
+	}
+	if len(files) == 0 {
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nNo files to clean up in %v\n", strings.Join(scanned, ", "))
+		}
+		return nil
+	}
+	if u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "\nThese files will be removed:")
+		for _, f := range files {
+			fmt.Fprintf(u.Stdout, "\n  %v", f)
+		}
+		fmt.Fprintln(u.Stdout)
+	}
+	if assumeYes == false && askYes("Remove these files?") == false {
+		return nil
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+		addEvent("cleanup", f, true)
+		if u.Verbose == true && u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\n%v removed", f)
+		}
+	}
+	if u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "\n%v files removed\n", len(files))
+	}
+	return nil
+}
+
+// reportConfPermissions compares the permissions of the files in confDir with the expectedMode.
+func reportConfPermissions(confDir string, expectedMode os.FileMode) ([]PermReport, error) {
+	var reports []PermReport
+	err := filepath.Walk(confDir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		mode := info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		reports = append(reports, PermReport{
+			Path:         name,
+			ActualMode:   mode,
+			ExpectedMode: expectedMode,
+			IsCorrect:    mode == expectedMode,
+		})
+		return nil
+	})
+	return reports, err
+}
+
+// printPermReport lists the files in confDir that do not have the expected permissions.
+func (u *Updater) printPermReport(confDir string, expected os.FileMode) error {
+	reports, err := reportConfPermissions(confDir, expected)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(u.Stdout, "\nPermissions of %v, expected %v", confDir, octal(expected))
+	c := 0
+	for _, r := range reports {
+		if r.IsCorrect {
+			continue
+		}
+		c++
+		fmt.Fprintf(u.Stdout, "\n  %-6v %v", octal(r.ActualMode), r.Path)
+	}
+	if c == 0 {
+		fmt.Fprintf(u.Stdout, "%v all files match", prefix)
+	}
+	return nil
+}
+
+// fileMode converts Unix permission bits, including setuid, setgid and sticky, to a FileMode.
+func fileMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode) & os.ModePerm
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// octal returns the FileMode as Unix octal permission bits.
+func octal(m os.FileMode) string {
+	mode := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return "0" + strconv.FormatUint(uint64(mode), 8)
+}
+
+// checkWorldWritable returns the files and directories in dir that are world-writable.
+// Symbolic links are skipped.
+func checkWorldWritable(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		if info.Mode()&0002 != 0 {
+			paths = append(paths, name)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// fixWorldWritable removes the world-writable permission from the named file.
+func fixWorldWritable(name string) error {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return os.Chmod(name, info.Mode()&^0002)
+}
+
+// changeOwner sets the user and group ownership of dir and its content.
+// When recursive is false only dir and the entries directly within it are changed.
+func (u *Updater) changeOwner(ctx context.Context, dir string, recursive bool, uID, gID int) error {
+	if u.dryRunSkip("change the ownership of %v to %v:%v", dir, uID, gID) {
+		return nil
+	}
+	var c, skipped int
+	if recursive == false {
+		err := os.Chown(dir, uID, gID)
+		if err != nil {
+			return err
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for i, f := range files {
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+			name := filepath.Join(dir, f.Name())
+			if u.Verbose == true {
+				fmt.Fprintf(u.Stdout, "\n%v. %v", i+1, name)
+			}
+			if u.skipChown == true && ownedBy(f, uID, gID) {
+				skipped++
+				continue
+			}
+			c++
+			err = os.Lchown(name, uID, gID)
+			if u.Verbose == true && err != nil {
+				fmt.Fprintf(u.Stdout, "%v failed", prefix)
+			}
+		}
+		u.printChownCount(c, skipped)
+		return nil
+	}
+	// count the entries for a progress counter that is quieter than --verbose
+	total, counter := 0, u.Quiet == false && u.Verbose == false
+	var printed time.Time
+	if counter {
+		filepath.WalkDir(dir, func(_ string, _ fs.DirEntry, err error) error {
+			if err == nil {
+				total++
+			}
+			return ctx.Err()
+		})
+		fmt.Fprintln(u.Stdout)
+	}
+	// the file info is only read when needed by --skip-chown-if-correct
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if u.Verbose == true {
+			fmt.Fprintf(u.Stdout, "\n%v. %v", c+skipped+1, name)
+		}
+		if counter && time.Since(printed) >= 200*time.Millisecond {
+			fmt.Fprintf(u.Stdout, "\rChowning files: %v/~%v", c+skipped, total)
+			printed = time.Now()
+		}
+		if u.skipChown == true {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if ownedBy(info, uID, gID) {
+				skipped++
+				return nil
+			}
+		}
+		c++
+		err = os.Chown(name, uID, gID)
+		if u.Verbose == true && err != nil {
+			fmt.Fprintf(u.Stdout, "%v failed", prefix)
+		}
+		return nil
+	})
+	if counter {
+		fmt.Fprintf(u.Stdout, "\rChowning files: %v/~%v", c+skipped, total)
+	}
+	u.printChownCount(c, skipped)
+	return err
+}
+
+// lookupUserID returns the numeric ID of the user name or ID.
+func lookupUserID(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	usr, err := user.Lookup(name)
+	if err != nil {
+		return -1, fmt.Errorf("The --user %q could not be found: %v", name, err)
+	}
+	return strconv.Atoi(usr.Uid)
+}
+
+// lookupGroupID returns the numeric ID of the group name or ID.
+func lookupGroupID(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	grp, err := user.LookupGroup(name)
+	if err != nil {
+		return -1, fmt.Errorf("The --group %q could not be found: %v", name, err)
+	}
+	return strconv.Atoi(grp.Gid)
+}
+
+// printChownCount reports the number of updated and skipped files when --skip-chown-if-correct is used.
+func (u *Updater) printChownCount(updated, skipped int) {
+	if u.skipChown == true && u.Verbose == true {
+		fmt.Fprintf(u.Stdout, "\nOwnership updated for %v files, %v already correct", updated, skipped)
+	}
+}
+
+// calcHash returns the checksum of the file using the hash.
+func calcHash(filePath string, h hash.Hash) ([]byte, error) {
+	var result []byte
+	file, err := os.Open(filePath)
+	if err != nil {
+		return result, err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return result, err
+	}
+
+	return h.Sum(result), nil
+}
+
+// checksumHash returns the hash that created the hex encoded checksum,
+// or nil if the checksum is not SHA-512, SHA-256 or SHA1.
+func checksumHash(checksum string) hash.Hash {
+	switch len(checksum) {
+	case sha512.Size * 2:
+		return sha512.New()
+	case sha256.Size * 2:
+		return sha256.New()
+	case sha1.Size * 2:
+		return sha1.New()
+	}
+	return nil
+}
+
+// checksumExt returns the file extension of the checksum.
+func checksumExt(checksum string) string {
+	switch len(checksum) {
+	case sha512.Size * 2:
+		return ".sha512"
+	case sha256.Size * 2:
+		return ".sha256"
+	}
+	return ".sha1"
+}
+
+// fileChecksum returns the checksum of the file using the hash of the expected checksum.
+// An empty string is returned if the file cannot be read.
+func fileChecksum(name, checksum string) string {
+	h := checksumHash(checksum)
+	if h == nil {
+		return ""
+	}
+	sum, err := calcHash(name, h)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sum)
+}
+
+// cacheName returns the path of the archive at url within the download directory.
+func cacheName(url, dir string) string {
+	return filepath.Join(dir, path.Base(url))
+}
+
+// cachedFetch returns the path of the archive at url saved in dir, which is downloaded
+// unless a file with the same checksum is already there. The checksum is also saved to
+// a checksum file next to the archive.
+func (u *Updater) cachedFetch(ctx context.Context, url, checksum, dir string) (string, error) {
+	name := cacheName(url, dir)
+	if fileChecksum(name, checksum) == checksum {
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "%v skipped file exists", prefix)
+		}
+		return name, nil
+	}
+	if dir != "" && u.dryRunSkip("create the download directory %v", dir) == false {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+	}
+	if err := u.download(ctx, name, url, checksum); err != nil {
+		return "", err
+	}
+	if dir == "" || u.dryRun == true {
+		return name, nil
+	}
+	data := fmt.Sprintf("%v  %v\n", checksum, filepath.Base(name))
+	return name, ioutil.WriteFile(name+checksumExt(checksum), []byte(data), 0644)
+}
+
+func (u *Updater) cp(rootDir string, subDir string, files ...string) error {
+	inFile, outFile := "", ""
+	inDir := filepath.Join(rootDir, subDir)
+	outDir := filepath.Join(u.TomcatDir, subDir)
+	total := len(files)
+
+	for i, f := range files {
+		inFile = filepath.Join(outDir, f)
+		outFile = filepath.Join(inDir, f)
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nMigrating config %d/%d: %v, %v will be replaced", i+1, total, f, outFile)
+		}
+		addFileEvent("config_migrate", f, i+1, total)
+
+		info, err := os.Stat(inFile)
+		if os.IsNotExist(err) {
+			if u.Quiet == false {
+				fmt.Fprintf(u.Stdout, "%v skipped, warning: %v does not exist", prefix, inFile)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%v is not a valid file", inFile)
+		}
+
+		if uint64(info.Size()) > maxConfSize {
+			err = fmt.Errorf("%v is %v which is larger than the %v limit", inFile, humanize.Bytes(uint64(info.Size())), humanize.Bytes(maxConfSize))
+			if u.strict == true {
+				return err
+			}
+			if u.Quiet == false {
+				fmt.Fprintf(u.Stdout, "%v skipped, %v", prefix, err)
+			}
+			continue
+		}
+
+		if confLinter != "" {
+			if err = lintConf(confLinter, inFile); err != nil {
+				if u.strict == true {
+					return err
+				}
+				lintFailures++
+				if u.Quiet == false {
+					fmt.Fprintf(u.Stdout, "%v skipped, %v", prefix, err)
+				}
+				continue
+			}
+		}
+
+		if placeholders == true {
+			if err = u.checkPlaceholders(inFile); err != nil {
+				return err
+			}
+		}
+
+		if showDiff == true && u.Quiet == false {
+			d, err := diffFiles(outFile, inFile)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(u.Stdout, "\n%v", d)
+		}
+
+		if u.dryRunSkip("replace %v with %v", outFile, inFile) {
+			continue
+		}
+
+		inCS, err := calcHash(inFile, sha512.New())
+		if err != nil {
+			return err
+		}
+		if lineEnding != "preserve" {
+			inCS, err = calcNormalisedSHA512(inFile, lineEnding)
+			if err != nil {
+				return err
+			}
+		}
+
+		in, err := os.Open(inFile)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.Create(outFile)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		if lineEnding == "preserve" {
+			_, err = io.Copy(out, in) // _ returns file size
+		} else {
+			err = normaliseLineEndings(in, out, lineEnding)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = out.Sync()
+		if err != nil {
+			return err
+		}
+
+		outCS, err := calcHash(outFile, sha512.New())
+		if err != nil {
+			return err
+		}
+
+		if fmt.Sprint(outCS) != fmt.Sprint(inCS) {
+			return fmt.Errorf("%v did not copy correctly, aborting", inFile)
+		}
+		addEvent("copy", outFile, true)
+
+		if detectEnc == true && transcode == true {
+			enc, err := detectFileEncoding(outFile)
+			if err != nil {
+				return err
+			}
+			if enc != "UTF-8" {
+				err = transcodeToUTF8(outFile, enc)
+				if err != nil {
+					return err
+				}
+				if u.Quiet == false {
+					fmt.Fprintf(u.Stdout, "%v transcoded from %v to UTF-8", prefix, enc)
+				}
+			}
+		}
+
+		if stripDebug == true && filepath.Base(outFile) == "logging.properties" {
+			c, err := stripDebugLogging(outFile, outFile)
+			if err != nil {
+				return err
+			}
+			if u.Verbose == true {
+				fmt.Fprintf(u.Stdout, "%v %v debug log levels replaced", prefix, c)
+			}
+		}
+
+		if sortProps == true && strings.ToLower(filepath.Ext(outFile)) == ".properties" {
+			err = sortPropertiesFile(outFile, outFile)
+			if err != nil {
+				return err
+			}
+		}
+
+		if placeholders == true {
+			if err = u.checkPlaceholders(outFile); err != nil {
+				return err
+			}
+		}
+
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "%v done", prefix)
+		}
+
+		// warn about a broken XML configuration instead of aborting mid-migration
+		if strings.ToLower(filepath.Ext(outFile)) == ".xml" {
+			if err = checkWellFormed(outFile); err != nil {
+				addEvent("xml_check", fmt.Sprintf("%v is not well-formed XML: %v", outFile, err), false)
+				if u.Quiet == false {
+					fmt.Fprintf(u.Stdout, "\nWarning: %v is not well-formed XML: %v", outFile, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// splitExtraFiles returns the source directory and destination sub-directory
+// of a -copy-extra-files srcDir:dstSubDir value.
+func splitExtraFiles(value string) (string, string, error) {
+	i := strings.LastIndex(value, ":")
+	if i < 1 || i == len(value)-1 {
+		return "", "", fmt.Errorf("The --copy-extra-files value %q must use the srcDir:dstSubDir format", value)
+	}
+	src, sub := value[:i], filepath.Clean(value[i+1:])
+	if filepath.IsAbs(sub) || sub == ".." || strings.HasPrefix(sub, ".."+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("The --copy-extra-files sub-directory %q must be within the Tomcat install", value[i+1:])
+	}
+	return src, sub, nil
+}
+
+// splitSymlink returns the symlink of a --symlink target:link value.
+func splitSymlink(value string) (SymlinkPair, error) {
+	i := strings.LastIndex(value, ":")
+	if i < 1 || i == len(value)-1 {
+		return SymlinkPair{}, fmt.Errorf("The --symlink value %q must use the target:link format", value)
+	}
+	s := SymlinkPair{Target: value[:i], Link: value[i+1:]}
+	return s, s.check()
+}
+
+// copyExtraFiles copies the files in srcDir and its sub-directories to dstDir.
+// Existing files are replaced only when overwrite is set, and files in dstDir that
+// are not in srcDir are kept. Each copy is verified with a checksum.
+// The number of copied files is returned.
+func copyExtraFiles(srcDir, dstDir string, overwrite bool) (int, error) {
+	c := 0
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dstDir, rel)
+		if info.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if _, err := os.Stat(dst); err == nil && !overwrite {
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		if _, err = io.Copy(out, in); err != nil {
+			return err
+		}
+		if err = out.Sync(); err != nil {
+			return err
+		}
+		inCS, err := calcHash(path, sha512.New())
+		if err != nil {
+			return err
+		}
+		outCS, err := calcHash(dst, sha512.New())
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(inCS, outCS) {
+			return fmt.Errorf("The copy of %v to %v failed as the checksums do not match", path, dst)
+		}
+		addEvent("copy", dst, true)
+		c++
+		return nil
+	})
+	return c, err
+}
+
+// detectPlaceholders returns the unresolved configuration management placeholders
+// such as ${DB_PASSWORD} or @@HOSTNAME@@ found in the file.
+func detectPlaceholders(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	re := regexp.MustCompile(`\$\{[A-Z_]+\}|@@[A-Z_]+@@`)
+	return re.FindAllString(string(data), -1), nil
+}
+
+// checkPlaceholders reports any placeholders in the file and returns an error unless --allow-placeholders is set.
+func (u *Updater) checkPlaceholders(path string) error {
+	found, err := detectPlaceholders(path)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	if u.allowHolders == false {
+		return fmt.Errorf("%v contains unresolved placeholders: %v\nUse --allow-placeholders to migrate it anyway", path, strings.Join(found, ", "))
+	}
+	if u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "\nWarning: %v contains unresolved placeholders: %v", path, strings.Join(found, ", "))
+	}
+	return nil
+}
+
+// normaliseLineEndings copies src to dst replacing all CRLF and lone CR or LF line endings.
+// The ending is either lf or crlf.
+func normaliseLineEndings(src io.Reader, dst io.Writer, ending string) error {
+	var eol string
+	switch ending {
+	case "lf":
+		eol = "\n"
+	case "crlf":
+		eol = "\r\n"
+	default:
+		return fmt.Errorf("unknown line ending %q", ending)
+	}
+	r, w := bufio.NewReader(src), bufio.NewWriter(dst)
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch b {
+		case '\r':
+			if next, err := r.Peek(1); err == nil && next[0] == '\n' {
+				r.ReadByte()
+			}
+			w.WriteString(eol)
+		case '\n':
+			w.WriteString(eol)
+		default:
+			w.WriteByte(b)
+		}
+	}
+	return w.Flush()
+}
+
+// calcNormalisedSHA512 returns the SHA-512 checksum of the file after its line endings are normalised.
+func calcNormalisedSHA512(filePath, ending string) ([]byte, error) {
+	var result []byte
+	file, err := os.Open(filePath)
+	if err != nil {
+		return result, err
+	}
+	defer file.Close()
+
+	hash := sha512.New()
+	if err := normaliseLineEndings(file, hash, ending); err != nil {
+		return result, err
+	}
+
+	return hash.Sum(result), nil
+}
+
+func (u *Updater) createLink(target, symlink string) error {
+	if validateLinks == true {
+		if err := validateSymlinkTarget(target); err != nil {
+			if skipInvalid == false {
+				return err
+			}
+			addEvent("symlink", fmt.Sprintf("%v → %v", symlink, target), false)
+			if u.Quiet == false {
+				fmt.Fprintf(u.Stdout, "\nSymlink %v → %v%v skipped %v", symlink, target, prefix, err)
+			}
+			return nil
+		}
+	}
+	if u.dryRunSkip("symlink %v → %v", symlink, target) {
+		return nil
+	}
+	err := os.Symlink(target, symlink)
+	addEvent("symlink", fmt.Sprintf("%v → %v", symlink, target), err == nil)
+	if u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "\nSymlink %v → %v", symlink, target)
+		if err != nil {
+			// display instead of log errors
+			es := strings.Fields(fmt.Sprint(err))
+			fmt.Fprintf(u.Stdout, "%v skipped %v", prefix, strings.Join(es[3:], " ")) // fetch and append error reason
+		}
+	}
+	return nil
+}
+
+func (u *Updater) download(ctx context.Context, filename string, url string, checksum string) error {
+	// download remote file metadata
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+	head, err := u.doWithRetry(hreq, retries+1)
+	if err != nil {
+		return err
+	}
+	if err = u.checkHTTP(head); err != nil {
+		return err
+	}
+	if head.ContentLength < 0 {
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nWarning: the server did not provide the size of %v", filename)
+		}
+	} else if uint64(head.ContentLength) > maxArchiveSize {
+		return fmt.Errorf("The download of %v was aborted as it is %v, which is larger than the %v limit", filename, humanize.Bytes(uint64(head.ContentLength)), humanize.Bytes(maxArchiveSize))
+	}
+	err = u.checkFreeSpace(filename, head.ContentLength)
+	if err != nil {
+		return err
+	}
+	if u.dryRunSkip("download %v, %v to %v", url, humanize.Bytes(uint64(head.ContentLength)), filename) {
+		return nil
+	}
+	addEvent("download_start", url, true)
+	// resume a partial download left by an interrupted run
+	var offset int64
+	if info, err := os.Stat(filename); err == nil && info.Size() > 0 && info.Size() < head.ContentLength {
+		offset = info.Size()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	if u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "\nDownloading file: %v, %v", filename, humanize.Bytes(uint64(head.ContentLength)))
+		lm := head.Header.Get("Last-Modified")
+		if len(lm) != 0 {
+			fmt.Fprintf(u.Stdout, ", %v\n", lm)
+		}
+	}
+	// download remote file data
+	resp, err := u.doWithRetry(req, retries+1)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// append to the local file when the server returns the requested range,
+	// otherwise replace it with the complete download
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		flags = os.O_WRONLY | os.O_APPEND
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nResuming from %v", humanize.Bytes(uint64(offset)))
+		}
+	} else if err = u.checkHTTP(resp); err != nil {
+		return err
+	}
+	lfn, err := os.OpenFile(filename, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer lfn.Close()
+	// save download to local file
+	var body io.Reader = resp.Body
+	bar := &progress{out: u.Stderr, total: head.ContentLength, done: offset, start: time.Now()}
+	if u.Quiet == false {
+		body = io.TeeReader(resp.Body, bar)
+	}
+	n, err := io.Copy(lfn, body)
+	if u.Quiet == false {
+		bar.finish()
+	}
+	if err != nil {
+		return err
+	}
+	addEvent("download_bytes", fmt.Sprintf("%v bytes written to %v", offset+n, filename), true)
+	// validate the download after it is complete
+	h := checksumHash(checksum)
+	if h == nil {
+		return fmt.Errorf("The checksum %q of %v is not a SHA-512, SHA-256 or SHA1 checksum", checksum, filename)
+	}
+	calc, err := calcHash(filename, h)
+	if err != nil {
+		return err
+	}
+	ccs := fmt.Sprintf("%x", calc)
+	addEvent("checksum", fmt.Sprintf("%v %v", ccs, filename), ccs == checksum)
+	if ccs != checksum {
+		return fmt.Errorf("The download failed as the checksum of %v does not match the expected checksum\nExpected: %q\n  Actual: %q", filename, checksum, ccs)
+	}
+	if u.Quiet == false {
+		fmt.Fprintln(u.Stdout, "Download complete")
+	}
+	return nil
+}
+
+// checkFreeSpace returns an error if the file system of the Tomcat directory does not have
+// room to download and extract an archive of size bytes, plus the --min-free-mb overhead.
+func (u *Updater) checkFreeSpace(filename string, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	need := uint64(size) + uint64(minFreeMB)*humanize.MByte
+	free, err := freeSpace(u.TomcatDir)
+	if os.IsNotExist(err) || errors.Is(err, ErrFreeSpace) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if free < need {
+		return fmt.Errorf("The download of %v was aborted as %v has %v free which is less than the %v required", filename, u.TomcatDir, humanize.Bytes(free), humanize.Bytes(need))
+	}
+	return nil
+}
+
+// progress prints the progress of a download to out.
+type progress struct {
+	out     io.Writer
+	total   int64 // size of the download or -1 if unknown
+	done    int64 // bytes received, including those of a resumed download
+	resumed int64 // bytes received during this run
+	start   time.Time
+	printed time.Time
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	p.resumed += int64(len(b))
+	if time.Since(p.printed) >= 200*time.Millisecond {
+		p.print()
+	}
+	return len(b), nil
+}
+
+// print overwrites the progress line with the percentage and throughput of the download.
+func (p *progress) print() {
+	p.printed = time.Now()
+	rate := uint64(0)
+	if secs := time.Since(p.start).Seconds(); secs > 0 {
+		rate = uint64(float64(p.resumed) / secs)
+	}
+	if p.total > 0 {
+		fmt.Fprintf(p.out, "\r%3d%% %v of %v, %v/s   ", p.done*100/p.total, humanize.Bytes(uint64(p.done)), humanize.Bytes(uint64(p.total)), humanize.Bytes(rate))
+		return
+	}
+	fmt.Fprintf(p.out, "\r%v, %v/s   ", humanize.Bytes(uint64(p.done)), humanize.Bytes(rate))
+}
+
+// finish prints the final progress line.
+func (p *progress) finish() {
+	p.print()
+	fmt.Fprintln(p.out)
+}
+
+// Extract decompresses the tar.gz archive src to a temporary tarball that is extracted
+// to destDir, or the working directory when empty. The temporary tarball is always removed.
+// The name of the top-level extracted directory is returned, along with an error wrapping
+// ErrContentRejected if files were rejected by the --extract-filter-cmd command.
+func (u *Updater) Extract(ctx context.Context, src, destDir string) (string, error) {
+	reader, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return "", err
+	}
+	defer gz.Close()
+	// decompress to a temporary tarball next to the extracted files
+	dir := destDir
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, ".tomcatupdate-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	if u.Verbose == true {
+		fmt.Fprintf(u.Stdout, "\nTarball %v", tmp.Name())
+	}
+	if _, err = io.Copy(tmp, gz); err != nil {
+		return "", err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	// unpack tarball
+	if u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "\nTarball content extraction")
+	}
+	rejected, err := u.extractTar(ctx, tmp, destDir, src)
+	if err != nil {
+		return "", err
+	}
+	if u.Verbose == true {
+		fmt.Fprintf(u.Stdout, "\nCompleted tarball content extraction")
+	} else if u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "%v done", prefix)
+	}
+	top := filepath.Join(destDir, strings.TrimSuffix(filepath.Base(src), ".tar.gz"))
+	if len(rejected) > 0 {
+		return top, fmt.Errorf("%w, %v files were rejected by %v:\n  %v", ErrContentRejected, len(rejected), filterCmd, strings.Join(rejected, "\n  "))
+	}
+	return top, nil
+}
+
+// extractTar extracts the tarball read from r, named source, to the target directory.
+// Files rejected by the --extract-filter-cmd command are returned.
+func (u *Updater) extractTar(ctx context.Context, r io.Reader, target, source string) ([]string, error) {
+	// files are written by a pool of workers, directories and links remain sequential
+	var rejected []string
+	var saveErr error
+	var mu sync.Mutex
+	save := func(e extractJob) {
+		ok, err := writeEntry(e.path, e.head.FileInfo().Mode(), e.data)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if saveErr == nil {
+				saveErr = err
+			}
+			return
+		}
+		if !ok {
+			rejected = append(rejected, e.head.Name)
+			if u.Verbose == true {
+				fmt.Fprintf(u.Stdout, "\n%v rejected", e.head.Name)
+			}
+		}
+	}
+	jobs := make(chan extractJob, extractWorkers)
+	var wg sync.WaitGroup
+	if extractWorkers > 1 {
+		for i := 0; i < extractWorkers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for e := range jobs {
+					save(e)
+				}
+			}()
+		}
+	}
+	// stop the workers before returning an error
+	abort := func(err error) ([]string, error) {
+		close(jobs)
+		wg.Wait()
+		return nil, err
+	}
+	// loop and read through tarball
+	c, tar, dir := 0, tar.NewReader(r), ""
+	links := 0
+	root, err := filepath.Abs(filepath.Join(target, "."))
+	if err != nil {
+		return abort(err)
+	}
+	if r, err := filepath.EvalSymlinks(root); err == nil {
+		root = r
+	}
 	for {
+		if err = ctx.Err(); err != nil {
+			return abort(err)
+		}
 		head, err := tar.Next()
 		if err == io.EOF {
 			break
-		} else {
-			checkErr(err)
+		} else if err != nil {
+			return abort(err)
+		}
+		// get item (dir or file) that must remain within the extraction directory
+		dir = filepath.Join(root, head.Name)
+		if filepath.IsAbs(head.Name) || (dir != root && !strings.HasPrefix(dir, root+string(filepath.Separator))) {
+			return abort(fmt.Errorf("%w: %v", ErrOutsideTarget, head.Name))
 		}
-		// get item (dir or file)
-		dir = filepath.Join(target, head.Name)
 		info := head.FileInfo()
 		c++
-		if verbose == true {
-			fmt.Printf("\n%v. %v", c, head.Name)
+		if c > tarEntryLimit {
+			return abort(fmt.Errorf("%w, the limit is %v", ErrTooManyEntries, tarEntryLimit))
 		}
-		// skip items that are to be ignored
-		spl = strings.Split(head.Name, "/")
-		if len(spl) >= 3 {
-			chk = fmt.Sprintf("%v/%v", spl[1], spl[2])
-		} else if len(spl) == 2 {
-			chk = fmt.Sprintf("%v", spl[1])
-		}
-		skip = false
-		for _, p := range ignored {
-			if chk == p {
-				skip = true
-				continue
-			}
+		if u.Verbose == true {
+			fmt.Fprintf(u.Stdout, "\n%v. %v", c, head.Name)
 		}
-		if skip == true {
-			if verbose == true {
-				fmt.Printf("%v skipped", prefix)
+		// skip items that are to be ignored
+		if shouldSkip(head.Name, ignored) {
+			if u.Verbose == true {
+				fmt.Fprintf(u.Stdout, "%v skipped", prefix)
 			}
 			continue
 		}
 		// handle (create) directories
 		if info.IsDir() {
 			if err = os.MkdirAll(dir, info.Mode()); err != nil {
-				checkErr(err)
+				return abort(err)
+			}
+			continue
+		}
+		// handle (link) symbolic links that must remain within the extraction directory
+		if info.Mode()&os.ModeSymlink != 0 {
+			links++
+			if links > tarSymlinkLimit {
+				return abort(fmt.Errorf("%w, the limit is %v", ErrTooManySymlinks, tarSymlinkLimit))
+			}
+			os.Remove(dir)
+			if err = os.Symlink(head.Linkname, dir); err != nil {
+				return abort(err)
+			}
+			if !linkWithin(root, dir, head.Linkname) {
+				if err = os.Remove(dir); err != nil {
+					return abort(err)
+				}
+				log.Printf("WARN: removed the symlink %v as it points outside of %v to %v", head.Name, root, head.Linkname)
 			}
 			continue
 		}
+		// keep files completed by an earlier, interrupted run
+		if recovery == true {
+			if fi, err := os.Lstat(dir); err == nil && fi.Mode().IsRegular() && fi.Size() == head.Size {
+				if u.Verbose == true {
+					fmt.Fprintf(u.Stdout, "%v exists", prefix)
+				}
+				continue
+			}
+		}
 		// handle (copy) files
-		file, err := os.OpenFile(dir, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
-		checkErr(err)
-		defer file.Close()
-		_, err = io.Copy(file, tar)
-		checkErr(err)
+		data, err := ioutil.ReadAll(tar)
+		if err != nil {
+			return abort(err)
+		}
+		if extractWorkers > 1 {
+			jobs <- extractJob{head, dir, data}
+			continue
+		}
+		save(extractJob{head, dir, data})
+		if saveErr != nil {
+			return abort(saveErr)
+		}
 	}
-	return strings.TrimSuffix(source, filepath.Ext(source))
+	close(jobs)
+	wg.Wait()
+	if saveErr != nil {
+		return nil, saveErr
+	}
+	sort.Strings(rejected)
+	addEvent("extract", fmt.Sprintf("%v entries from %v", c, source), true)
+	return rejected, nil
 }
 
-func openGZip(source, target string) string {
-	// open gzip archive (only supports a single file extraction)
-	reader, err := os.Open(source)
-	checkErr(err)
-	defer reader.Close()
-	// read archive
-	gz, err := gzip.NewReader(reader)
-	checkErr(err)
+// extractJob is a file of a tarball waiting to be written.
+type extractJob struct {
+	head *tar.Header
+	path string
+	data []byte
+}
+
+// writeEntry saves the data of a tarball file to name, or passes it to the
+// --extract-filter-cmd command when set. False is returned if the filter rejects the file.
+func writeEntry(name string, mode os.FileMode, data []byte) (bool, error) {
+	if filterCmd != "" {
+		return filterFile(name, mode, bytes.NewReader(data))
+	}
+	return true, ioutil.WriteFile(name, data, mode)
+}
+
+// shouldSkip returns true if the tarball item name, without its top-level directory,
+// is one of the ignored paths or is within an ignored directory.
+func shouldSkip(name string, ignored []string) bool {
+	spl := strings.SplitN(strings.TrimSuffix(name, "/"), "/", 2)
+	if len(spl) < 2 {
+		return false
+	}
+	for _, p := range ignored {
+		if spl[1] == p || strings.HasPrefix(spl[1], p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// linkWithin returns true if the symbolic link resolves to a path within the root directory.
+// Links to targets that do not yet exist are resolved lexically.
+func linkWithin(root, link, target string) bool {
+	dest, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		dest = target
+		if !filepath.IsAbs(dest) {
+			dest = filepath.Join(filepath.Dir(link), dest)
+		}
+	}
+	dest, err = filepath.Abs(dest)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, dest)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// filterFile saves r to a temporary file that is passed to the --extract-filter-cmd command.
+// If the command succeeds the file is renamed to name, otherwise it is discarded and false is returned.
+func filterFile(name string, mode os.FileMode, r io.Reader) (bool, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".tomcatupdate-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+	if err = os.Chmod(tmp.Name(), mode); err != nil {
+		return false, err
+	}
+	args := strings.Fields(filterCmd)
+	cmd := exec.Command(args[0], append(args[1:], tmp.Name())...)
+	if err = cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), name)
+}
+
+// streamExtract downloads the tar.gz archive at url and extracts it to destDir in a
+// single pass, without saving the archive. The archive is hashed as it is read and
+// compared to the checksum once the stream ends. Unless --skip-gpg is set the PGP
+// signature of the stream is also verified.
+func (u *Updater) streamExtract(ctx context.Context, url, checksum, destDir string) error {
+	h := checksumHash(checksum)
+	if h == nil {
+		return fmt.Errorf("The checksum %q of %v is not a SHA-512, SHA-256 or SHA1 checksum", checksum, url)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := u.doWithRetry(req, retries+1)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Download %v: %v. Maybe check %v for the current version?", url, resp.Status, downloadPage(u.Major))
+	}
+	if resp.ContentLength > 0 && uint64(resp.ContentLength) > maxArchiveSize {
+		return fmt.Errorf("The download of %v was aborted as it is %v, which is larger than the %v limit", url, humanize.Bytes(uint64(resp.ContentLength)), humanize.Bytes(maxArchiveSize))
+	}
+	if err = u.checkFreeSpace(url, resp.ContentLength); err != nil {
+		return err
+	}
+	addEvent("download_start", url, true)
+	if u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "\nStreaming file: %v, %v\n", url, humanize.Bytes(uint64(resp.ContentLength)))
+	}
+	bar := &progress{out: u.Stderr, total: resp.ContentLength, start: time.Now()}
+	if u.Quiet == true {
+		bar.out = ioutil.Discard
+	}
+	writers := []io.Writer{h, bar}
+	// verify the signature of the stream as it is read
+	var pw *io.PipeWriter
+	type verified struct {
+		signer string
+		err    error
+	}
+	done := make(chan verified, 1)
+	if u.skipGPG == false {
+		var pr *io.PipeReader
+		pr, pw = io.Pipe()
+		writers = append(writers, pw)
+		go func() {
+			signer, err := u.verifyStream(pr, url+".asc", u.Major)
+			pr.CloseWithError(err)
+			done <- verified{signer, err}
+		}()
+	}
+	body := io.TeeReader(resp.Body, io.MultiWriter(writers...))
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return err
+	}
 	defer gz.Close()
-	// create a filename
-	if len(gz.Name) == 0 {
-		ext := path.Ext(source)
-		gz.Name = source[0 : len(source)-len(ext)]
+	rejected, err := u.extractTar(ctx, gz, destDir, url)
+	if err != nil {
+		return err
 	}
-	if len(target) != 0 {
-		target = filepath.Join(target, gz.Name)
-	} else {
-		target = gz.Name
+	// read the rest of the stream so all of the archive is checked
+	if _, err = io.Copy(ioutil.Discard, gz); err != nil {
+		return err
+	}
+	if _, err = io.Copy(ioutil.Discard, body); err != nil {
+		return err
 	}
-	if quiet == false {
-		fmt.Printf("\nTarball %v", target)
+	bar.finish()
+	addEvent("download_bytes", fmt.Sprintf("%v bytes streamed from %v", bar.done, url), true)
+	var v verified
+	if pw != nil {
+		pw.Close()
+		v = <-done
 	}
-	// create an empty file
-	writer, err := os.Create(target)
-	checkErr(err)
-	defer func() {
-		writer.Close()
-		if quiet == false {
-			fmt.Printf("%v created", prefix)
+	sum := fmt.Sprintf("%x", h.Sum(nil))
+	addEvent("checksum", fmt.Sprintf("%v %v", sum, url), sum == checksum)
+	if sum != checksum {
+		return fmt.Errorf("%w, %v\nExpected: %q\n  Actual: %q", ErrChecksum, url, checksum, sum)
+	}
+	if pw != nil {
+		if errors.Is(v.err, ErrSignature) {
+			return v.err
 		}
-	}()
-	// save extracted tarball to empty file
-	_, err = io.Copy(writer, gz)
-	checkErr(err)
-	return target
+		if v.err != nil {
+			return fmt.Errorf("%w, %v: %v", ErrSignature, url, v.err)
+		}
+		if u.Quiet == false {
+			fmt.Fprintf(u.Stdout, "\nPGP signature of %v verified, signed by %v", url, v.signer)
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("%w, %v files were rejected by %v:\n  %v", ErrContentRejected, len(rejected), filterCmd, strings.Join(rejected, "\n  "))
+	}
+	return nil
 }
 
-func getChecksum(url string) string {
-	resp, err := http.Get(url)
-	checkErr(err)
-	checkSumHTTP(url, resp)
-	checkHTTP(resp)
-	// Save download to local file
+// fetchWithRetry GETs the url, retrying transient failures up to maxAttempts in total.
+func (u *Updater) fetchWithRetry(url string, maxAttempts int) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return u.doWithRetry(req, maxAttempts)
+}
+
+// doWithRetry sends the request, retrying network errors and 5xx server errors
+// with an exponential backoff that starts at one second. Other responses,
+// including 4xx client errors, are returned immediately.
+func (u *Updater) doWithRetry(req *http.Request, maxAttempts int) (*http.Response, error) {
+	wait := time.Second
+	for attempt := 1; ; attempt++ {
+		resp, err := u.HTTPClient.Do(req)
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+		if attempt >= maxAttempts || errors.Is(err, context.Canceled) {
+			return resp, err
+		}
+		reason := fmt.Sprint(err)
+		if err == nil {
+			reason = resp.Status
+			resp.Body.Close()
+		}
+		if u.Verbose == true {
+			fmt.Fprintf(u.Stdout, "\nRetrying %v %v in %v, attempt %d of %d failed: %v", req.Method, req.URL, wait, attempt, maxAttempts, reason)
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (u *Updater) getChecksum(url string) (string, error) {
+	resp, err := u.fetchWithRetry(url, retries+1)
+	if err != nil {
+		return "", err
+	}
 	defer resp.Body.Close()
+	u.checkSumHTTP(url, resp)
+	if err = u.checkHTTP(resp); err != nil {
+		return "", err
+	}
+	// Save download to local file
 	data, err := ioutil.ReadAll(resp.Body)
-	checkErr(err)
-	cs := string(strings.Split(fmt.Sprintf("%s", data), "*")[0])
-	cs = strings.TrimSpace(cs)
-	return cs
+	if err != nil {
+		return "", err
+	}
+	return parseChecksum(string(data)), nil
+}
+
+// checksumExts are the checksum files published with the archives, strongest first.
+var checksumExts = []string{".sha512", ".sha256", ".sha1"}
+
+// probeChecksum returns the URL of the strongest checksum file published for the archive.
+// The fallback URL is returned when no checksum file can be found.
+func (u *Updater) probeChecksum(archiveURL, fallback string) string {
+	for _, ext := range checksumExts {
+		resp, err := u.HTTPClient.Head(archiveURL + ext)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return archiveURL + ext
+		}
+	}
+	return fallback
+}
+
+// readSHA256File returns the checksum of a local .sha256 file, using any of the
+// formats handled by parseChecksum.
+func readSHA256File(name string) (string, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	sum := parseChecksum(string(data))
+	if len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("%v does not contain a SHA-256 checksum", name)
+	}
+	return sum, nil
 }
 
+// parseChecksum returns the checksum from the content of a checksum file
+// in the bare hex, "hex *filename" or "hex  filename" formats.
+func parseChecksum(data string) string {
+	fields := strings.Fields(data)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(fields[0], "*"))
+}
+
+// checkErr exits the program if there is an error, it is only used by main
+// as the other functions return their errors.
 func checkErr(err error) {
 	if err != nil {
-		if logErrs == true {
-			log.Fatal("ERROR: ", err)
-		} else {
-			fmt.Printf("\n%s\n", err)
-			os.Exit(0)
+		exit("ERROR: ", err, errCode)
+	}
+}
+
+// exit reports the error and quits the tool with the exit code.
+func exit(label string, err error, code int) {
+	removePID()
+	removeArchives()
+	unlockFile(lock)
+	if jsonOutput == false {
+		syslogMsg("ERROR", fmt.Sprint(err))
+	}
+	switch {
+	case jsonOutput == true:
+		addEvent("error", fmt.Sprint(err), false)
+		printEvents()
+	case summary == true:
+		if fileLog != nil {
+			fileLog.Print(label, err)
 		}
+		printSummary(err)
+	case quietErrs == true:
+		if fileLog != nil {
+			fileLog.Print(label, err)
+		}
+	case logErrs == true:
+		log.Print(label, err)
+	default:
+		fmt.Printf("\n%s\n", err)
 	}
+	os.Exit(code)
 }
 
-func checkSumHTTP(url string, r *http.Response) {
-	if r.StatusCode != 200 {
-		err := fmt.Errorf("Checksum file%v: %v", filepath.Ext(url), r.Status)
-		fmt.Printf("\n%s", err)
+// checkSumHTTP prints the status of a checksum file request that failed.
+func (u *Updater) checkSumHTTP(url string, r *http.Response) {
+	if r.StatusCode != 200 && u.Quiet == false {
+		fmt.Fprintf(u.Stdout, "\nChecksum file%v: %v", filepath.Ext(url), r.Status)
 	}
 }
 
-func checkHTTP(r *http.Response) {
+// checkHTTP returns an error if the archive request failed.
+func (u *Updater) checkHTTP(r *http.Response) error {
 	if r.StatusCode != 200 {
-		err := fmt.Errorf("Download file.tar.gz: %v. Maybe check %v for the current version?", r.Status, urlPage)
-		if logErrs == true {
-			log.Fatal("SERVER ERROR: ", err)
-		} else {
-			fmt.Printf("\n%s\n", err)
-			os.Exit(0)
-		}
+		return fmt.Errorf("Download file.tar.gz: %v. Maybe check %v for the current version?", r.Status, downloadPage(u.Major))
+	}
+	return nil
+}
+
+// printSummary prints the one line JSON result of the run for --summary.
+func printSummary(err error) {
+	host, _ := os.Hostname()
+	s := runSummary{
+		Status:    "ok",
+		Version:   release,
+		Duration:  time.Since(started).Milliseconds(),
+		Host:      host,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err != nil {
+		s.Status = "error"
+		s.Error = err.Error()
+		s.Phase = phase
+	}
+	b, _ := json.Marshal(s)
+	fmt.Println(string(b))
+}
+
+// addEvent records an operation of the run for --json, the --log-file and --syslog.
+func addEvent(typ, detail string, ok bool) {
+	level := "INFO"
+	switch {
+	case typ == "error":
+		level = "ERROR"
+	case ok == false:
+		level = "WARN"
+	}
+	if fileLog != nil {
+		fileLog.Printf("%v: %v %v", level, typ, detail)
+	}
+	syslogMsg(level, fmt.Sprintf("%v %v", typ, detail))
+	if jsonOutput == false {
+		return
+	}
+	events = append(events, Event{Type: typ, Detail: detail, OK: strconv.FormatBool(ok)})
+}
+
+// addFileEvent records the operation on the file, number index of the total files of
+// the operation, for --json, the --log-file and --syslog.
+func addFileEvent(typ, file string, index, total int) {
+	addEvent(typ, fmt.Sprintf("%v %d/%d", file, index, total), true)
+	if jsonOutput == true {
+		e := &events[len(events)-1]
+		e.File, e.Index, e.Total = file, index, total
+	}
+}
+
+// printEvents prints the events of the run as a JSON array for --json.
+func printEvents() {
+	if events == nil {
+		events = []Event{}
 	}
+	b, _ := json.MarshalIndent(events, "", "  ")
+	fmt.Println(string(b))
 }
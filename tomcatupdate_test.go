@@ -0,0 +1,609 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// testArchive returns a Tomcat like tar.gz archive with a top-level directory.
+func testArchive(t *testing.T, top string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gz)
+	files := map[string]string{
+		"conf/server.xml":        `<Server port="8005" shutdown="SHUTDOWN"></Server>`,
+		"RELEASE-NOTES":          "Apache Tomcat Version 9.0.1",
+		"webapps/ROOT/index.jsp": "<html></html>",
+	}
+	dirs := []string{top + "/", top + "/conf/", top + "/webapps/", top + "/webapps/ROOT/"}
+	for _, d := range dirs {
+		if err := tw.WriteHeader(&tar.Header{Name: d, Mode: 0755, Typeflag: tar.TypeDir}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range files {
+		h := &tar.Header{Name: top + "/" + name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestReadSHA256File(t *testing.T) {
+	dir := t.TempDir()
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte("tomcat")))
+	tests := []struct {
+		name string
+		data string
+		ok   bool
+	}{
+		{"bare", sum + "\n", true},
+		{"binary", sum + " *apache-tomcat-9.0.1.tar.gz\n", true},
+		{"text", sum + "  apache-tomcat-9.0.1.tar.gz\n", true},
+		{"empty", "", false},
+		{"sha512", sum + sum + "  apache-tomcat-9.0.1.tar.gz\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, tt.name+".sha256")
+			if err := ioutil.WriteFile(name, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readSHA256File(name)
+			if tt.ok == false {
+				if err == nil {
+					t.Errorf("readSHA256File() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != sum {
+				t.Errorf("readSHA256File() = %q, want %q", got, sum)
+			}
+		})
+	}
+}
+
+// TestSHA256FileLocalArchive verifies and extracts an archive using a local checksum file,
+// both when the archive is already local and when it must be downloaded.
+func TestSHA256FileLocalArchive(t *testing.T) {
+	const top = "apache-tomcat-9.0.1"
+	archive := testArchive(t, top)
+	var requests int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path != "/"+top+".tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, top+".tar.gz", started, bytes.NewReader(archive))
+	}))
+	defer srv.Close()
+	url := srv.URL + "/" + top + ".tar.gz"
+
+	dir := t.TempDir()
+	sumFile := filepath.Join(dir, top+".tar.gz.sha256")
+	data := fmt.Sprintf("%x *%v.tar.gz\n", sha256.Sum256(archive), top)
+	if err := ioutil.WriteFile(sumFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := readSHA256File(sumFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Updater{
+		TomcatDir:  filepath.Join(dir, "missing"),
+		Quiet:      true,
+		HTTPClient: srv.Client(),
+		Stdout:     ioutil.Discard,
+		Stderr:     ioutil.Discard,
+	}
+	ctx := context.Background()
+
+	t.Run("local", func(t *testing.T) {
+		cache := filepath.Join(dir, "local")
+		if err := os.Mkdir(cache, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(cache, top+".tar.gz"), archive, 0644); err != nil {
+			t.Fatal(err)
+		}
+		atomic.StoreInt32(&requests, 0)
+		name, err := u.cachedFetch(ctx, url, sum, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := atomic.LoadInt32(&requests); n != 0 {
+			t.Errorf("cachedFetch() sent %v requests for a local archive, want 0", n)
+		}
+		dest := filepath.Join(dir, "local-extract")
+		if err := os.Mkdir(dest, 0755); err != nil {
+			t.Fatal(err)
+		}
+		got, err := u.Extract(ctx, name, dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(dest, top); got != want {
+			t.Errorf("Extract() = %q, want %q", got, want)
+		}
+		if _, err := os.Stat(filepath.Join(got, "conf", "server.xml")); err != nil {
+			t.Error(err)
+		}
+		if _, err := os.Stat(filepath.Join(got, "webapps", "ROOT")); os.IsNotExist(err) == false {
+			t.Errorf("Extract() did not skip the ignored webapps/ROOT, %v", err)
+		}
+	})
+
+	t.Run("download", func(t *testing.T) {
+		cache := filepath.Join(dir, "download")
+		if err := os.Mkdir(cache, 0755); err != nil {
+			t.Fatal(err)
+		}
+		atomic.StoreInt32(&requests, 0)
+		name, err := u.cachedFetch(ctx, url, sum, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := atomic.LoadInt32(&requests); n == 0 {
+			t.Error("cachedFetch() did not download the missing archive")
+		}
+		if got := fileChecksum(name, sum); got != sum {
+			t.Errorf("fileChecksum() = %q, want %q", got, sum)
+		}
+		if _, err := os.Stat(name + ".sha256"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		cache := filepath.Join(dir, "mismatch")
+		if err := os.Mkdir(cache, 0755); err != nil {
+			t.Fatal(err)
+		}
+		wrong := fmt.Sprintf("%x", sha256.Sum256([]byte("tampered")))
+		if _, err := u.cachedFetch(ctx, url, wrong, cache); err == nil {
+			t.Error("cachedFetch() of an archive that does not match the checksum file, want an error")
+		}
+	})
+}
+
+// TestManifestRuntimeDirs checks the files Tomcat changes as it runs are neither listed
+// in the manifest nor reported by the verification.
+func TestManifestRuntimeDirs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("bin/catalina.sh", "#!/bin/sh")
+	write("conf/server.xml", "<Server/>")
+	write("logs/catalina.out", "started")
+	write("temp/upload.tmp", "data")
+	write("work/Catalina/localhost/ROOT/index_jsp.java", "class")
+	write("webapps/app.war", "war")
+	write("webapps/app/index.jsp", "<html></html>")
+	write(lockName, "")
+	ctx := context.Background()
+	m, err := buildManifest(ctx, dir, "9.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range m.Files {
+		got = append(got, f.Path)
+	}
+	if want := []string{"bin/catalina.sh", "conf/server.xml"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("buildManifest() listed %v, want %v", got, want)
+	}
+	if err = writeManifest(filepath.Join(dir, manifestName), m); err != nil {
+		t.Fatal(err)
+	}
+	write("logs/catalina.out", "stopped")
+	write("logs/localhost_access_log.txt", "GET /")
+	write("webapps/app/index.jsp", "<html>redeployed</html>")
+	code, err := verifyInstall(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != ExitOK {
+		t.Errorf("verifyInstall() = %v after runtime changes, want %v", code, ExitOK)
+	}
+	write("conf/server.xml", "<Server port=\"8006\"/>")
+	if code, err = verifyInstall(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	if code != ExitVerifyFailed {
+		t.Errorf("verifyInstall() = %v after a configuration change, want %v", code, ExitVerifyFailed)
+	}
+}
+
+func TestShouldSkip(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"apache-tomcat-9.0.1/LICENSE", true},
+		{"apache-tomcat-9.0.1/webapps/docs/", true},
+		{"apache-tomcat-9.0.1/webapps/docs/index.html", true},
+		{"apache-tomcat-9.0.1/webapps/docs-extra/index.html", false},
+		{"apache-tomcat-9.0.1/conf/server.xml", false},
+		{"apache-tomcat-9.0.1/", false},
+		{"LICENSE", false},
+	}
+	for _, tt := range tests {
+		if got := shouldSkip(tt.name, ignored); got != tt.want {
+			t.Errorf("shouldSkip(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSortPropertiesFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"sorted", "b=2\na=1\n", "a=1\nb=2\n"},
+		{"case", "B=2\na=1\n", "a=1\nB=2\n"},
+		{"comments", "# header\n\n# b comment\nb=2\na=1\n", "# header\n\na=1\n# b comment\nb=2\n"},
+		{"continuation", "b=one \\\n  two\na=1\n", "a=1\nb=one \\\n  two\n"},
+		{"separators", "c 3\nb:2\na=1\n", "a=1\nb:2\nc 3\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, tt.name+".properties")
+			if err := ioutil.WriteFile(name, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := sortPropertiesFile(name, name); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("sortPropertiesFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripDebugLogging(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name  string
+		data  string
+		want  string
+		count int
+	}{
+		{"fine", "org.apache.level = FINE\n", "org.apache.level = INFO\n", 1},
+		{"root", ".level=ALL\n", ".level=INFO\n", 1},
+		{"crlf", "handlers.level = finest\r\n", "handlers.level = INFO\r\n", 1},
+		{"comment", "# org.apache.level = FINE\n", "# org.apache.level = FINE\n", 0},
+		{"warning", "org.apache.level = WARNING\n", "org.apache.level = WARNING\n", 0},
+		{"mixed", "a.level = FINER\nb.level = SEVERE\nc.level: FINE\n", "a.level = INFO\nb.level = SEVERE\nc.level: INFO\n", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := filepath.Join(dir, tt.name+".properties")
+			dst := filepath.Join(dir, tt.name+"-stripped.properties")
+			if err := ioutil.WriteFile(src, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			c, err := stripDebugLogging(src, dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c != tt.count {
+				t.Errorf("stripDebugLogging() replaced %v levels, want %v", c, tt.count)
+			}
+			got, err := ioutil.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("stripDebugLogging() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetAttr(t *testing.T) {
+	tests := []struct {
+		tag, name, value string
+		want             string
+	}{
+		{`<Connector port="8080" protocol="HTTP/1.1">`, "port", "9090", `<Connector port="9090" protocol="HTTP/1.1">`},
+		{`<Connector port='8080'/>`, "port", "9090", `<Connector port="9090"/>`},
+		{`<Connector protocol="AJP/1.3"/>`, "address", "127.0.0.1", `<Connector protocol="AJP/1.3" address="127.0.0.1" />`},
+		{`<Server port="8005">`, "shutdown", "STOP", `<Server port="8005" shutdown="STOP">`},
+		{`<Connector redirectPort="8443">`, "port", "9090", `<Connector redirectPort="8443" port="9090">`},
+		{`<Server shutdown="SHUTDOWN">`, "shutdown", "a&b", `<Server shutdown="a&amp;b">`},
+	}
+	for _, tt := range tests {
+		if got := string(setAttr([]byte(tt.tag), tt.name, tt.value)); got != tt.want {
+			t.Errorf("setAttr(%q, %q, %q) = %q, want %q", tt.tag, tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestEditXML(t *testing.T) {
+	dir := t.TempDir()
+	const serverXML = `<Server port="8005" shutdown="SHUTDOWN">
+  <Service name="Catalina">
+    <Connector port="8080" protocol="HTTP/1.1"/>
+    <Connector port="8009" protocol="AJP/1.3"/>
+  </Service>
+</Server>
+`
+	http11 := func(attrs []xml.Attr) bool {
+		p, _ := attrValue(attrs, "protocol")
+		return p == "HTTP/1.1"
+	}
+	port := func(tag []byte) []byte {
+		return setAttr(tag, "port", "9090")
+	}
+	tests := []struct {
+		name  string
+		data  string
+		match func([]xml.Attr) bool
+		want  string
+		count int
+	}{
+		{"match", serverXML, http11, strings.Replace(serverXML, `port="8080"`, `port="9090"`, 1), 1},
+		{"all", serverXML, func([]xml.Attr) bool { return true }, strings.NewReplacer(`port="8080"`, `port="9090"`, `port="8009"`, `port="9090"`).Replace(serverXML), 2},
+		{"none", serverXML, func([]xml.Attr) bool { return false }, serverXML, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, tt.name+".xml")
+			if err := ioutil.WriteFile(name, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			c, err := editXML(name, "Connector", tt.match, port)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c != tt.count {
+				t.Errorf("editXML() edited %v elements, want %v", c, tt.count)
+			}
+			got, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("editXML() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		name := filepath.Join(dir, "invalid.xml")
+		if err := ioutil.WriteFile(name, []byte(`<Server><Connector port=8080/></Server>`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := editXML(name, "Connector", http11, port); err == nil {
+			t.Error("editXML() of invalid XML, want an error")
+		}
+	})
+}
+
+func TestAppendOpts(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name  string
+		data  string // content of the existing setenv.sh, none when empty
+		flags []string
+		want  string
+		ok    bool
+	}{
+		{"missing", "", []string{"-Xmx1g"}, "#!/bin/sh\nJAVA_OPTS=\"${JAVA_OPTS} -Xmx1g\"\n", true},
+		{"expanded", "#!/bin/sh\nJAVA_OPTS=\"$JAVA_OPTS -Xms512m\"\n", []string{"-Xmx1g"}, "#!/bin/sh\nJAVA_OPTS=\"$JAVA_OPTS -Xms512m -Xmx1g\"\n", true},
+		{"export", "export JAVA_OPTS=\"-Xms512m\"\n", []string{"-Xmx1g"}, "export JAVA_OPTS=\"${JAVA_OPTS} -Xms512m -Xmx1g\"\n", true},
+		{"duplicate", "JAVA_OPTS=\"${JAVA_OPTS} -Xmx1g\"\n", []string{"-Xmx1g"}, "JAVA_OPTS=\"${JAVA_OPTS} -Xmx1g\"\n", true},
+		{"last", "JAVA_OPTS=\"-Xms256m\"\nJAVA_OPTS=\"-Xms512m\"\n", []string{"-Xmx1g"}, "JAVA_OPTS=\"-Xms256m\"\nJAVA_OPTS=\"${JAVA_OPTS} -Xms512m -Xmx1g\"\n", true},
+		{"hyphen", "", []string{"Xmx1g"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, tt.name+".sh")
+			if tt.data != "" {
+				if err := ioutil.WriteFile(name, []byte(tt.data), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := appendOpts(name, "JAVA_OPTS", tt.flags)
+			if tt.ok == false {
+				if err == nil {
+					t.Error("appendOpts() of a flag without a hyphen, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("appendOpts() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// testTransport sends every request to the test server and records the requested URLs.
+type testTransport struct {
+	srv  *httptest.Server
+	urls []string
+}
+
+func (t *testTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.urls = append(t.urls, r.URL.String())
+	req := r.Clone(r.Context())
+	req.URL.Scheme, req.URL.Host = "http", strings.TrimPrefix(t.srv.URL, "http://")
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestLatestVersion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download-90.cgi":
+			fmt.Fprint(w, `<a href="#9.0.85">9.0.85</a> <a href="#9.0.87">9.0.87</a> <a href="#8.5.99">8.5.99</a>`)
+		case "/download-10.cgi":
+			fmt.Fprint(w, `<a href="#10.1.20">10.1.20</a> <a href="#10.0.27">10.0.27</a>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	tr := &testTransport{srv: srv}
+	var out bytes.Buffer
+	u := &Updater{
+		Major:      "8",
+		Minor:      "5",
+		HTTPClient: &http.Client{Transport: tr},
+		Stdout:     &out,
+		Stderr:     ioutil.Discard,
+	}
+
+	tests := []struct {
+		installed, want, page string
+		wantErr               bool
+	}{
+		{"9.0.80", "9.0.87", "https://tomcat.apache.org/download-90.cgi", false},
+		{"10.1.1", "10.1.20", "https://tomcat.apache.org/download-10.cgi", false},
+		{"11.0.0", "", "https://tomcat.apache.org/download-11.cgi", true},
+		{"9.0", "", "", true},
+	}
+	for _, tt := range tests {
+		tr.urls = nil
+		got, err := u.latestVersion(tt.installed)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("latestVersion(%q) error = %v, want error %v", tt.installed, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("latestVersion(%q) = %q, want %q", tt.installed, got, tt.want)
+		}
+		if tt.page != "" && (len(tr.urls) != 1 || tr.urls[0] != tt.page) {
+			t.Errorf("latestVersion(%q) requested %v, want %v", tt.installed, tr.urls, tt.page)
+		}
+	}
+	if u.Major != "8" || u.Minor != "5" {
+		t.Errorf("latestVersion() changed the series of the Updater to %v.%v", u.Major, u.Minor)
+	}
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "RELEASE-NOTES"), []byte("Apache Tomcat Version 9.0.80"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	code, err := u.checkUpdate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != ExitUpdateAvailable {
+		t.Errorf("checkUpdate() = %v, want %v", code, ExitUpdateAvailable)
+	}
+	if want := "Installed: 9.0.80  Available: 9.0.87  (update available)\n"; out.String() != want {
+		t.Errorf("checkUpdate() printed %q, want %q", out.String(), want)
+	}
+}
+
+func TestCheckPlaceholders(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "server.xml")
+	if err := ioutil.WriteFile(name, []byte(`<Server port="${SHUTDOWN_PORT}"></Server>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	u := &Updater{Stdout: &out, Stderr: ioutil.Discard}
+	if err := u.checkPlaceholders(name); err == nil {
+		t.Error("checkPlaceholders() of an unresolved placeholder, want an error")
+	}
+	u.allowHolders = true
+	if err := u.checkPlaceholders(name); err != nil {
+		t.Errorf("checkPlaceholders() with allowHolders = %v, want nil", err)
+	}
+	if !strings.Contains(out.String(), "${SHUTDOWN_PORT}") {
+		t.Errorf("checkPlaceholders() printed %q, want the placeholder warning", out.String())
+	}
+	out.Reset()
+	u.Quiet = true
+	if err := u.checkPlaceholders(name); err != nil {
+		t.Error(err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("checkPlaceholders() printed %q when quiet", out.String())
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	dir := t.TempDir()
+	remove := []string{"apache-tomcat-9.0.1.tar.gz", "apache-tomcat-9.0.1.tar.gz.sha512", "tomcatupdate-dryrun-1.txt"}
+	keep := []string{"server.xml", "apache-tomcat-9.0.1"}
+	for _, f := range append(remove, keep...) {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	u := &Updater{Stdout: &out, Stderr: ioutil.Discard}
+	if err := u.cleanup([]string{dir, dir + string(filepath.Separator), ""}, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range remove {
+		if _, err := os.Stat(filepath.Join(dir, f)); os.IsNotExist(err) == false {
+			t.Errorf("cleanup() did not remove %v", f)
+		}
+	}
+	for _, f := range keep {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Errorf("cleanup() removed %v: %v", f, err)
+		}
+	}
+	if want := fmt.Sprintf("\n%v files removed\n", len(remove)); !strings.HasSuffix(out.String(), want) {
+		t.Errorf("cleanup() printed %q, want the suffix %q", out.String(), want)
+	}
+	out.Reset()
+	u.Quiet = true
+	if err := u.cleanup([]string{dir}, true); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("cleanup() printed %q when quiet", out.String())
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+// usr1.go - answer the version check signal sent during an update
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyUSR1 reports that an update is already in progress whenever SIGUSR1 is received.
+// The returned func stops the notifications.
+func (u *Updater) notifyUSR1() func() {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			if u.Quiet == false {
+				fmt.Fprintf(u.Stdout, "\n%v version check requested, an update is already in progress", prefix)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(usr1)
+	}
+}
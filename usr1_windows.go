@@ -0,0 +1,8 @@
+// usr1_windows.go - answer the version check signal sent during an update
+
+package main
+
+// notifyUSR1 does nothing as Windows does not support SIGUSR1.
+func (u *Updater) notifyUSR1() func() {
+	return func() {}
+}